@@ -0,0 +1,97 @@
+package drivefs
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"maps"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// How write handles replace file content
+type AtomicMode int
+
+const (
+	// Upload direct to file, Google Drive only publish resumable upload after last chunk
+	AtomicNone AtomicMode = iota
+
+	// Same as AtomicNone, but new files are only created after upload finish,
+	// without empty file in folder while writing
+	AtomicRevision
+
+	// Upload to hidden node in same folder and rename over original file on Close,
+	// file ID and shared permissions are from new node, original node is moved to trash
+	AtomicRename
+)
+
+// Return atomic mode from options or gdrive default
+func (gdrive *Gdrive) atomicMode(options *CreateOptions) AtomicMode {
+	if options != nil && options.Atomic != AtomicNone {
+		return options.Atomic
+	}
+	return gdrive.Atomic
+}
+
+// Check if node is hidden node from AtomicRename upload
+func isTempNode(node *drive.File) bool { return node.AppProperties[TempAppProperties] != "" }
+
+// Upload session to hidden node in same folder of node
+func tempSession(session *UploadSession, node *drive.File) {
+	session.FileID, session.replace = "", node
+	session.Metadata.Name = fmt.Sprintf(".%s.drivefs-%s", node.Name, rand.Text()[:8])
+	session.Metadata.Parents = node.Parents
+	session.Metadata.MimeType = node.MimeType
+	if session.Metadata.Description == "" {
+		session.Metadata.Description = node.Description
+	}
+
+	for key, value := range node.Properties {
+		if _, ok := session.Metadata.Properties[key]; !ok {
+			session.Metadata.Properties[key] = value
+		}
+	}
+	session.Metadata.AppProperties = maps.Clone(node.AppProperties)
+	if session.Metadata.AppProperties == nil {
+		session.Metadata.AppProperties = map[string]string{}
+	}
+	session.Metadata.AppProperties[TempAppProperties] = node.Id
+}
+
+// Rename uploaded hidden node and remove temporary mark
+func (gdrive *Gdrive) publishTemp(temp *drive.File, name string) (*drive.File, error) {
+	update := &drive.File{Name: name, NullFields: []string{"AppProperties." + TempAppProperties}}
+	node, err := gdrive.driveService.Files.Update(temp.Id, update).Fields("*").Do()
+	if err != nil {
+		return nil, ProcessErr(fileRes(node), err)
+	}
+
+	if gdrive.cacheDir != nil && len(temp.Parents) > 0 {
+		gdrive.cacheDir.Delete(temp.Parents[0])
+	}
+	return node, nil
+}
+
+// Move original node to trash and rename uploaded hidden node to original name.
+//
+// Original node is restored from trash if rename fail
+func (gdrive *Gdrive) renameOver(original, temp *drive.File) (*drive.File, error) {
+	if err := gdrive.checkConflict(original); err != nil {
+		return gdrive.tempConflict(original, temp, err)
+	}
+
+	// Trash before rename to not have two files with same name
+	if res, err := gdrive.driveService.Files.Update(original.Id, &drive.File{Trashed: true}).Do(); err != nil {
+		return nil, ProcessErr(fileRes(res), err)
+	}
+
+	node, err := gdrive.publishTemp(temp, original.Name)
+	if err != nil {
+		restore := &drive.File{Trashed: false, ForceSendFields: []string{"Trashed"}}
+		if res, restoreErr := gdrive.driveService.Files.Update(original.Id, restore).Do(); restoreErr != nil {
+			return nil, errors.Join(err, ProcessErr(fileRes(res), restoreErr))
+		}
+		return nil, err
+	}
+	return node, nil
+}
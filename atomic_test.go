@@ -0,0 +1,54 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestRenameOver(t *testing.T) {
+	for _, failRename := range []bool{false, true} {
+		trashed, requests := map[string]bool{"original": false}, []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := path.Base(r.URL.Path)
+			requests = append(requests, r.Method+" "+id)
+			switch r.Method {
+			case http.MethodGet:
+				json.NewEncoder(w).Encode(&drive.File{Id: id, HeadRevisionId: "r1"})
+			case http.MethodPatch:
+				var update map[string]any
+				json.NewDecoder(r.Body).Decode(&update)
+				if id == "temp" && failRename {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				} else if value, ok := update["trashed"]; ok {
+					trashed[id] = value.(bool)
+				}
+				json.NewEncoder(w).Encode(&drive.File{Id: id, Name: "file.txt"})
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+
+		service, _ := testService(t, server)
+		gdrive := &Gdrive{driveService: service}
+		original := &drive.File{Id: "original", Name: "file.txt", HeadRevisionId: "r1"}
+
+		node, err := gdrive.renameOver(original, &drive.File{Id: "temp"})
+		switch {
+		case !failRename && (err != nil || node.Id != "temp"):
+			t.Errorf("rename over: %v", err)
+		case !failRename && !trashed["original"]:
+			t.Errorf("original not moved to trash: %v", requests)
+		case failRename && err == nil:
+			t.Errorf("rename failure not returned")
+		case failRename && trashed["original"]:
+			t.Errorf("original not restored from trash: %v", requests)
+		}
+		server.Close()
+	}
+}
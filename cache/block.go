@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Disk cache of fixed size blocks of files, with LRU size limit.
+//
+// Directory can be shared between process, blocks written by others are used on Get
+type Blocks struct {
+	Dir       string // Directory to save blocks
+	BlockSize int64  // Block size, blocks with other size in Dir are ignored
+	Limit     int64  // Max bytes in Dir, 0 to no limit
+
+	locker sync.Mutex
+	size   int64                    // Bytes in blocks
+	lru    *list.List               // Block files, most recent in front
+	files  map[string]*list.Element // Block file to element in lru
+}
+
+type blockFile struct {
+	name string
+	size int64
+}
+
+// Open block cache in dir, blocks saved in previous process are loaded to LRU
+func OpenBlocks(dir string, blockSize, limit int64) (*Blocks, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	blocks := &Blocks{Dir: dir, BlockSize: blockSize, Limit: limit, lru: list.New(), files: map[string]*list.Element{}}
+	infos := []fs.FileInfo{}
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(name) != ".block" {
+			return err
+		} else if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Last used in front
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int { return b.ModTime().Compare(a.ModTime()) })
+	for _, info := range infos {
+		name := blocks.blockPath(info.Name())
+		blocks.files[name] = blocks.lru.PushBack(&blockFile{name, info.Size()})
+		blocks.size += info.Size()
+	}
+	blocks.evict()
+	return blocks, nil
+}
+
+func (blocks *Blocks) blockPath(base string) string {
+	return filepath.Join(blocks.Dir, base[:2], base)
+}
+
+// Block file to key and index
+func (blocks *Blocks) fileName(key string, index int64) string {
+	sum := sha1.Sum(fmt.Appendf(nil, "%s:%d", key, blocks.BlockSize))
+	return blocks.blockPath(fmt.Sprintf("%s-%d.block", hex.EncodeToString(sum[:]), index))
+}
+
+// Get block index of key, return nil if not cached
+func (blocks *Blocks) Get(key string, index int64) []byte {
+	name := blocks.fileName(key, index)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	os.Chtimes(name, now, now) // Recent to others process
+	blocks.locker.Lock()
+	defer blocks.locker.Unlock()
+	if element, ok := blocks.files[name]; ok {
+		blocks.lru.MoveToFront(element)
+	} else {
+		blocks.files[name] = blocks.lru.PushFront(&blockFile{name, int64(len(data))})
+		blocks.size += int64(len(data))
+		blocks.evict()
+	}
+	return data
+}
+
+// Return true if block index of key is cached
+func (blocks *Blocks) Has(key string, index int64) bool {
+	_, err := os.Stat(blocks.fileName(key, index))
+	return err == nil
+}
+
+// Save block index of key, data is full block or end of file
+func (blocks *Blocks) Set(key string, index int64, data []byte) error {
+	name := blocks.fileName(key, index)
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+
+	// Write and rename to others process not read incomplete block
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	} else if err = tmp.Close(); err != nil {
+		return err
+	} else if err = os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	blocks.locker.Lock()
+	defer blocks.locker.Unlock()
+	if element, ok := blocks.files[name]; ok {
+		blocks.size -= element.Value.(*blockFile).size
+		blocks.lru.Remove(element)
+	}
+	blocks.files[name] = blocks.lru.PushFront(&blockFile{name, int64(len(data))})
+	blocks.size += int64(len(data))
+	blocks.evict()
+	return nil
+}
+
+// Remove least recently used blocks until size is below limit, locker must be held
+func (blocks *Blocks) evict() {
+	for blocks.Limit > 0 && blocks.size > blocks.Limit && blocks.lru.Len() > 0 {
+		element := blocks.lru.Back()
+		block := blocks.lru.Remove(element).(*blockFile)
+		delete(blocks.files, block.name)
+		blocks.size -= block.size
+		os.Remove(block.name)
+	}
+}
@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	blocks, err := OpenBlocks(t.TempDir(), 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, data := range [][]byte{[]byte("0123"), []byte("4567"), []byte("89")} {
+		if err := blocks.Set("file:rev", int64(index), data); err != nil {
+			t.Fatalf("cannot set block %d: %s", index, err)
+		}
+	}
+
+	if data := blocks.Get("file:rev", 0); data != nil {
+		t.Errorf("block 0 not evicted: %q", data)
+	} else if data := blocks.Get("file:rev", 2); !bytes.Equal(data, []byte("89")) {
+		t.Errorf("invalid block 2: %q", data)
+	} else if data := blocks.Get("file:other", 2); data != nil {
+		t.Errorf("block from other revision: %q", data)
+	}
+
+	// Reopen with blocks from previous process
+	if blocks, err = OpenBlocks(blocks.Dir, 4, 8); err != nil {
+		t.Fatal(err)
+	} else if data := blocks.Get("file:rev", 1); !bytes.Equal(data, []byte("4567")) {
+		t.Errorf("invalid block 1 after reopen: %q", data)
+	}
+}
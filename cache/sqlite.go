@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"iter"
 	"time"
+
+	_ "modernc.org/sqlite"
 )
 
 var _ Cache[any] = (*Sqlite[any])(nil)
 
+var SqliteBusyTimeout = time.Second * 10 // Wait lock held by other connection in OpenSqliteDB
+
 const (
 	SqliteListAllKeys    = `SELECT KEY_NAME, KEY_VALUE FROM %q WHERE LIMIT_TTL > ?;`                    // List all keys with value
 	SqliteDeleteOutdated = `DELETE FROM %q WHERE LIMIT_TTL < ?;`                                        // Delete rows if outdate
@@ -35,7 +39,17 @@ func OpenSqlite[T any](dataSourceName, dbName string) (Cache[T], error) {
 	if err != nil {
 		return nil, err
 	}
-	_, err = db.Exec(fmt.Sprintf(SqliteCreateTable, dbName))
+	return NewSqlite[T](db, dbName)
+}
+
+// Open sqlite file to share between tables, wait locks from other connections and write in WAL mode
+func OpenSqliteDB(file string) (*sql.DB, error) {
+	return sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", file, SqliteBusyTimeout.Milliseconds()))
+}
+
+// Create table dbName in db if not exists
+func NewSqlite[T any](db *sql.DB, dbName string) (Cache[T], error) {
+	_, err := db.Exec(fmt.Sprintf(SqliteCreateTable, dbName))
 	return &Sqlite[T]{DBName: dbName, DB: db}, err
 }
 
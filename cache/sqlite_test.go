@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -55,3 +56,31 @@ func TestDbSqlite(t *testing.T) {
 		return
 	}
 }
+
+func TestOpenSqliteDB(t *testing.T) {
+	db, err := OpenSqliteDB(filepath.Join(t.TempDir(), "shared.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mode string
+	var timeout int64
+	if err = db.QueryRow("PRAGMA journal_mode;").Scan(&mode); err != nil {
+		t.Fatal(err)
+	} else if err = db.QueryRow("PRAGMA busy_timeout;").Scan(&timeout); err != nil {
+		t.Fatal(err)
+	} else if mode != "wal" || timeout != SqliteBusyTimeout.Milliseconds() {
+		t.Errorf("journal mode %q and busy timeout %d", mode, timeout)
+	}
+
+	// Tables share connection pool
+	values, err := NewSqlite[Value](db, "values")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = NewSqlite[[]string](db, "ids"); err != nil {
+		t.Fatal(err)
+	} else if err = values.Set(time.Hour, "key", Value{Title: "shared"}); err != nil {
+		t.Fatal(err)
+	}
+}
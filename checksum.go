@@ -0,0 +1,85 @@
+package drivefs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Action to corrupt revision if uploaded checksum not match
+type ChecksumPolicy int
+
+const (
+	ChecksumKeep       ChecksumPolicy = iota // Keep corrupt revision and return ErrChecksum
+	ChecksumDelete                           // Delete corrupt revision, or file if it is only revision
+	ChecksumQuarantine                       // Copy corrupt revision to "<name>.corrupt" before delete it
+
+	CorruptAppProperties string = "drivefsCorrupt" // Revision ID of quarantined copy
+)
+
+// Hash content uploaded to compare with Google Drive checksums
+type uploadHash struct {
+	md5, sha1, sha256 hash.Hash
+	writer            io.Writer
+}
+
+func newUploadHash() *uploadHash {
+	h := &uploadHash{md5: md5.New(), sha1: sha1.New(), sha256: sha256.New()}
+	h.writer = io.MultiWriter(h.md5, h.sha1, h.sha256)
+	return h
+}
+
+func (h *uploadHash) Write(p []byte) (int, error) { return h.writer.Write(p) }
+
+// Compare checksums returned by Google Drive, node without checksum is valid
+func (h *uploadHash) verify(node *drive.File) error { return h.compare(node, ErrChecksum) }
+
+// Compare checksums of node, error wraps sentinel if any checksum not match
+func (h *uploadHash) compare(node *drive.File, sentinel error) error {
+	for _, check := range []struct {
+		name, remote string
+		local        hash.Hash
+	}{
+		{"md5", node.Md5Checksum, h.md5},
+		{"sha1", node.Sha1Checksum, h.sha1},
+		{"sha256", node.Sha256Checksum, h.sha256},
+	} {
+		if local := hex.EncodeToString(check.local.Sum(nil)); check.remote != "" && check.remote != local {
+			return fmt.Errorf("%w: %s %s, local %s", sentinel, check.name, check.remote, local)
+		}
+	}
+	return nil
+}
+
+// Delete or quarantine corrupt revision with gdrive.ChecksumPolicy
+func (gdrive *Gdrive) rejectRevision(node *drive.File) error {
+	switch gdrive.ChecksumPolicy {
+	case ChecksumQuarantine:
+		_, err := gdrive.driveService.Files.Copy(node.Id, &drive.File{
+			Name:          node.Name + ".corrupt",
+			Parents:       node.Parents,
+			AppProperties: map[string]string{CorruptAppProperties: node.HeadRevisionId},
+		}).Do()
+		if err != nil {
+			return ProcessErr(nil, err)
+		}
+		fallthrough
+	case ChecksumDelete:
+		revisions, err := gdrive.driveService.Revisions.List(node.Id).Fields("revisions(id)").Do()
+		if err != nil {
+			return ProcessErr(nil, err)
+		} else if len(revisions.Revisions) <= 1 {
+			err = gdrive.driveService.Files.Delete(node.Id).Do()
+		} else {
+			err = gdrive.driveService.Revisions.Delete(node.Id, node.HeadRevisionId).Do()
+		}
+		return ProcessErr(nil, err)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	iofs "io/fs"
 	"net"
 	"net/http"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"golang.org/x/oauth2"
 	"sirherobrine23.com.br/Sirherobrine23/cgofuse/fs"
@@ -25,6 +27,18 @@ var (
 
 var _ fs.FileSystem[drivefs.File] = (*drivefs.Gdrive)(nil)
 
+// Gdrive.Lock and Gdrive.Unlock are not wired to flock(2) or fcntl(2): cgofuse has no lock operation
+// in its FUSE interface, so locks taken on the mount are local to the kernel and not shared with other clients
+
+// Optional calls to FUSE chmod, chown, utimens and symlink. Handlers are in cgofuse fs adapter, not in drivefs,
+// so this only check Gdrive have the methods: the adapter must look up these methods to dispatch the calls
+var _ interface {
+	Chmod(name string, mode iofs.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Symlink(target, link string) error
+} = (*drivefs.Gdrive)(nil)
+
 func main() {
 	flag.Parse()
 	configFile, err := os.OpenFile(*Config, os.O_CREATE|os.O_RDWR, 0600)
@@ -134,5 +148,8 @@ func main() {
 	<-ctx.Done()
 	fmt.Fprintf(os.Stderr, "Unmount overlayfs\n")
 	fs.Done()
+	if err := gdriveClient.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
 	fmt.Fprintf(os.Stderr, "Unmounted overlayfs\n")
 }
@@ -0,0 +1,90 @@
+package drivefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Action when remote file changed since write handle opened
+type ConflictPolicy int
+
+const (
+	ConflictFail ConflictPolicy = iota // Refuse commit and return ErrConflict
+	ConflictCopy                       // Save write as "<name> (conflicted copy <host> <time>)" in same folder
+)
+
+// Return name to save write lost in conflict
+func conflictName(name string) string {
+	host, _ := os.Hostname()
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (conflicted copy %s %s)%s", strings.TrimSuffix(name, ext), host, time.Now().Format("2006-01-02 150405"), ext)
+}
+
+// Check if remote content changed since node was seen, metadata changes are not conflict.
+//
+// Nodes without head revision or md5 (folders, Google Workspace) are not checked
+func (gdrive *Gdrive) checkConflict(node *drive.File) error {
+	if node.Version == 0 || node.HeadRevisionId == "" && node.Md5Checksum == "" {
+		return nil // Node from deferred create or without content revision
+	}
+	remote, err := gdrive.driveService.Files.Get(node.Id).Fields("headRevisionId,md5Checksum").Do()
+	if err != nil {
+		return ProcessErr(fileRes(remote), err)
+	}
+
+	if node.HeadRevisionId != "" && remote.HeadRevisionId != node.HeadRevisionId {
+		return fmt.Errorf("%w: revision %s, expected %s", ErrConflict, remote.HeadRevisionId, node.HeadRevisionId)
+	} else if node.HeadRevisionId == "" && remote.Md5Checksum != node.Md5Checksum {
+		return fmt.Errorf("%w: md5 %s, expected %s", ErrConflict, remote.Md5Checksum, node.Md5Checksum)
+	}
+	return nil
+}
+
+// Change session to create conflicted copy of node in same folder
+func conflictSession(session *UploadSession, node *drive.File) {
+	session.FileID, session.expect = "", nil
+	session.Metadata.Name = conflictName(node.Name)
+	session.Metadata.Parents = node.Parents
+	if session.Metadata.MimeType == "" {
+		session.Metadata.MimeType = node.MimeType
+	}
+}
+
+// Cancel resumable session, content uploaded is discarded
+func (gdrive *Gdrive) cancelUpload(session *UploadSession) {
+	if req, err := http.NewRequest(http.MethodDelete, session.URI, nil); err == nil {
+		if res, err := gdrive.client.Do(req); err == nil {
+			res.Body.Close()
+		}
+	}
+	if gdrive.sessions != nil && session.key() != "" {
+		gdrive.sessions.Delete(session.key())
+	}
+}
+
+// Upload r content again to conflicted copy of session node
+func (gdrive *Gdrive) uploadConflict(session *UploadSession, node *drive.File, r io.ReadSeeker) (*drive.File, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	copySession := &UploadSession{Metadata: session.Metadata, ContentType: session.ContentType, name: session.name}
+	conflictSession(copySession, node)
+	return gdrive.upload(copySession, r)
+}
+
+// Resolve conflict to hidden node uploaded to replace node
+func (gdrive *Gdrive) tempConflict(original, temp *drive.File, err error) (*drive.File, error) {
+	if errors.Is(err, ErrConflict) && gdrive.ConflictPolicy == ConflictCopy {
+		return gdrive.publishTemp(temp, conflictName(original.Name))
+	}
+	return nil, errors.Join(err, ProcessErr(nil, gdrive.driveService.Files.Delete(temp.Id).Do()))
+}
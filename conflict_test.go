@@ -0,0 +1,31 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestCheckConflict(t *testing.T) {
+	remote := &drive.File{Id: "file", Version: 9, HeadRevisionId: "r1"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(remote)
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	gdrive := &Gdrive{driveService: service}
+
+	node := &drive.File{Id: "file", Version: 3, HeadRevisionId: "r1"}
+	if err := gdrive.checkConflict(node); err != nil {
+		t.Errorf("metadata update reported as conflict: %v", err)
+	}
+	remote.HeadRevisionId = "r2"
+	if err := gdrive.checkConflict(node); !errors.Is(err, ErrConflict) {
+		t.Errorf("new revision not reported as conflict: %v", err)
+	}
+}
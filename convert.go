@@ -0,0 +1,68 @@
+package drivefs
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Return true if new files in name are converted by ConvertFolders
+func (gdrive *Gdrive) convertFolder(name string) bool {
+	name = pathManipulate(path.Join(gdrive.SubDir, name)).CleanPath()
+	for _, folder := range gdrive.ConvertFolders {
+		if folder = pathManipulate(folder).CleanPath(); folder == "/" || strings.HasPrefix(name, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Options to create name, with Convert from ConvertFolders and content mime type from extension.
+//
+// Return options without change if content cannot be converted
+func (gdrive *Gdrive) convertOptions(name string, options *CreateOptions) *CreateOptions {
+	if (options == nil || !options.Convert) && !gdrive.convertFolder(name) {
+		return options
+	}
+
+	converted := CreateOptions{}
+	if options != nil {
+		converted = *options
+	}
+	if converted.MimeType == "" || converted.MimeType == GoogleDriveMimeFile {
+		converted.MimeType = gdrive.detectMime(name, nil)
+	}
+	if ImportFormats[converted.MimeType] == "" {
+		return options
+	}
+	converted.Convert = true
+	return &converted
+}
+
+// Name of converted file in Google Drive, extension is removed like Google Drive import
+func convertName(name string) string {
+	if base := strings.TrimSuffix(name, path.Ext(name)); base != "" {
+		return base
+	}
+	return name
+}
+
+// Save node uploaded to cache key, converted files are saved with name listed by Workspace or Google Drive name
+func (gdrive *Gdrive) cacheUploaded(key string, node *drive.File) *drive.File {
+	if gdrive.isWorkspace(node) {
+		node = gdrive.workspaceNode(node)
+	}
+	if gdrive.cache == nil {
+		return node
+	}
+
+	if node.Name == path.Base(key) {
+		gdrive.cache.Set(DefaultCacheTime, key, node)
+	} else if slices.Contains(DriveMimes, node.MimeType) {
+		gdrive.cache.Delete(key) // Converted and listed with other name
+		gdrive.cache.Set(DefaultCacheTime, path.Join(path.Dir(key), node.Name), node)
+	}
+	return node
+}
@@ -0,0 +1,96 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"sirherobrine23.com.br/Sirherobrine23/drivefs/cache"
+)
+
+func TestConvertOptions(t *testing.T) {
+	gdrive := &Gdrive{ConvertFolders: []string{"office"}}
+	for _, check := range []struct {
+		name     string
+		options  *CreateOptions
+		mimeType string // Content mime type, empty if not converted
+	}{
+		{"office/report.docx", nil, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"office/sub/budget.csv", nil, "text/csv"},
+		{"office/photo.png", nil, ""},
+		{"officer/report.docx", nil, ""},
+		{"slides.pptx", &CreateOptions{Convert: true}, "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+		{"notes.md", &CreateOptions{Convert: true, MimeType: "text/plain"}, "text/plain"},
+	} {
+		options := gdrive.convertOptions(check.name, check.options)
+		if converted := options != nil && options.Convert; converted != (check.mimeType != "") {
+			t.Errorf("%s: converted %v", check.name, converted)
+		} else if converted && options.MimeType != check.mimeType {
+			t.Errorf("%s: mime type %q, expected %q", check.name, options.MimeType, check.mimeType)
+		}
+	}
+
+	if name := convertName("report.docx"); name != "report" {
+		t.Errorf("converted name %q", name)
+	}
+}
+
+func TestConvertUpload(t *testing.T) {
+	var created drive.File
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			json.NewDecoder(r.Body).Decode(&created)
+			contentType = r.Header.Get("X-Upload-Content-Type")
+			w.Header().Set("Location", "http://"+r.Host+"/session")
+		case r.Method == http.MethodPut && r.URL.Path == "/session":
+			io.Copy(io.Discard, r.Body)
+			converted := created
+			converted.Id = "doc"
+			json.NewEncoder(w).Encode(&converted)
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(&drive.FileList{}) // File not exist
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	service, client := testService(t, server)
+	nodeCache, err := cache.OpenSqlite[*drive.File](filepath.Join(t.TempDir(), "cache.db"), "nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdrive := &Gdrive{
+		client:       client,
+		driveService: service,
+		cache:        nodeCache,
+		rootDrive:    &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder},
+		tracker:      &uploadTracker{files: map[io.Closer]struct{}{}},
+	}
+
+	file, err := gdrive.CreateWithOptions("report.docx", CreateOptions{Convert: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = file.Write([]byte("document")); err != nil {
+		t.Fatal(err)
+	} else if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if created.MimeType != GoogleDriveMimeDocument || created.Name != "report" {
+		t.Errorf("created %q with mime type %q, expected Google Docs", created.Name, created.MimeType)
+	} else if contentType != "application/vnd.openxmlformats-officedocument.wordprocessingml.document" {
+		t.Errorf("content type %q", contentType)
+	}
+	if node := file.(*LocalFile).Node; node.Id != "doc" || node.MimeType != GoogleDriveMimeDocument {
+		t.Errorf("returned node %q with mime type %q, expected converted", node.Id, node.MimeType)
+	} else if cached, _ := gdrive.cache.Get("report"); cached == nil || cached.Id != "doc" {
+		t.Errorf("converted node not cached with Google Drive name")
+	}
+}
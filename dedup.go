@@ -0,0 +1,97 @@
+package drivefs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Action to new file with same content of file in Google Drive
+type DedupMode int
+
+const (
+	DedupNone     DedupMode = iota // Upload all content
+	DedupCopy                      // Copy existing file in Google Drive
+	DedupShortcut                  // Create shortcut to existing file
+
+	DedupMinSize             = 1 << 20         // Smaller content is uploaded
+	HashAppProperties string = "drivefsSha256" // Sha256 of content, Google Drive not query checksums
+)
+
+// Query files tagged with sha256, %s is sha256 hex
+const GoogleListQueryWithHash = "trashed=false and mimeType != '" + GoogleDriveMimeSyslink + "' and appProperties has { key='" + HashAppProperties + "' and value='%s' }"
+
+// Create session node from file with same content of r.
+//
+// Return nil node if not found, and r is seeked to start to upload
+func (gdrive *Gdrive) dedupUpload(session *UploadSession, r io.ReadSeeker) (*drive.File, error) {
+	if strings.HasPrefix(session.Metadata.MimeType, "application/vnd.google-apps.") {
+		return nil, nil // Content converted to Google Workspace
+	}
+
+	sums := newUploadHash()
+	size, err := io.Copy(sums, r)
+	if err != nil {
+		return nil, err
+	} else if _, err = r.Seek(0, io.SeekStart); err != nil || size < DedupMinSize {
+		return nil, err
+	}
+
+	sha256Sum := hex.EncodeToString(sums.sha256.Sum(nil))
+	if session.Metadata.AppProperties == nil {
+		session.Metadata.AppProperties = map[string]string{}
+	}
+	session.Metadata.AppProperties[HashAppProperties] = sha256Sum
+
+	files, err := gdrive.driveService.Files.List().Fields("*").PageSize(10).Q(fmt.Sprintf(GoogleListQueryWithHash, sha256Sum)).Do()
+	if err != nil {
+		return nil, nil // Upload content if cannot query
+	}
+
+	for _, candidate := range files.Files {
+		if candidate.Size != size || sums.verify(candidate) != nil {
+			continue // Tag from older revision
+		}
+
+		var node *drive.File
+		if gdrive.Dedup == DedupShortcut {
+			shortcut := *session.Metadata
+			shortcut.MimeType, shortcut.ShortcutDetails = GoogleDriveMimeSyslink, &drive.FileShortcutDetails{TargetId: candidate.Id}
+			shortcut.AppProperties = maps.Clone(session.Metadata.AppProperties)
+			delete(shortcut.AppProperties, HashAppProperties)
+			node, err = gdrive.driveService.Files.Create(&shortcut).Fields("*").Do()
+		} else {
+			node, err = gdrive.driveService.Files.Copy(candidate.Id, session.Metadata).Fields("*").Do()
+		}
+		if err != nil {
+			return nil, ProcessErr(fileRes(node), err)
+		}
+
+		gdrive.dedupSaved.Add(size)
+		return node, nil
+	}
+	return nil, nil
+}
+
+// Save sha256 of content uploaded to node to find it in next uploads, return node updated
+func (gdrive *Gdrive) tagHash(node *drive.File, sums *uploadHash) *drive.File {
+	sha256Sum := hex.EncodeToString(sums.sha256.Sum(nil))
+	if node.AppProperties[HashAppProperties] == sha256Sum || node.Size < DedupMinSize {
+		return node
+	}
+
+	res, err := gdrive.driveService.Files.Update(node.Id, &drive.File{AppProperties: map[string]string{HashAppProperties: sha256Sum}}).Fields("*").Do()
+	if err != nil {
+		return node // Only not found in next uploads
+	}
+	return res
+}
+
+// Bytes not uploaded because content already in Google Drive
+func (gdrive *Gdrive) DedupSaved() int64 {
+	return gdrive.dedupSaved.Load()
+}
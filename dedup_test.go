@@ -0,0 +1,112 @@
+package drivefs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestDedupCreate(t *testing.T) {
+	content := bytes.Repeat([]byte("drivefs"), DedupMinSize/7+1)
+	sum := md5.Sum(content)
+	candidate := &drive.File{Id: "existing", Size: int64(len(content)), Md5Checksum: hex.EncodeToString(sum[:])}
+
+	created := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Query().Get("q"), HashAppProperties):
+			json.NewEncoder(w).Encode(&drive.FileList{Files: []*drive.File{candidate}})
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(&drive.FileList{}) // File not exist
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/existing/copy"):
+			var copied drive.File
+			json.NewDecoder(r.Body).Decode(&copied)
+			copied.Id = "copy"
+			json.NewEncoder(w).Encode(&copied)
+		default:
+			created = true
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	gdrive := &Gdrive{
+		Dedup:        DedupCopy,
+		driveService: service,
+		rootDrive:    &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder},
+		tracker:      &uploadTracker{files: map[io.Closer]struct{}{}},
+		dedupSaved:   &atomic.Int64{},
+	}
+
+	file, err := gdrive.Create("file.bin")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = file.Write(content); err != nil {
+		t.Fatal(err)
+	} else if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if created {
+		t.Errorf("file created or uploaded instead of copy")
+	} else if node := file.(*LocalFile).Node; node.Id != "copy" || node.Name != "file.bin" {
+		t.Errorf("node %q named %q, expected copy", node.Id, node.Name)
+	} else if saved := gdrive.DedupSaved(); saved != int64(len(content)) {
+		t.Errorf("saved %d bytes, expected %d", saved, len(content))
+	}
+}
+
+func TestDedupPipeWrite(t *testing.T) {
+	created := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(&drive.FileList{}) // File not exist
+		case r.Method == http.MethodPost && r.URL.Path == "/files":
+			var node drive.File
+			json.NewDecoder(r.Body).Decode(&node)
+			node.Id, created = "file", true
+			json.NewEncoder(w).Encode(&node)
+		case r.Method == http.MethodPatch:
+			w.Header().Set("Location", "http://"+r.Host+"/session")
+		case r.Method == http.MethodPut:
+			io.Copy(io.Discard, r.Body)
+			io.WriteString(w, `{"id":"file","name":"file.bin"}`)
+		}
+	}))
+	defer server.Close()
+
+	service, client := testService(t, server)
+	gdrive := &Gdrive{
+		Dedup:        DedupCopy,
+		client:       client,
+		driveService: service,
+		rootDrive:    &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder},
+		tracker:      &uploadTracker{files: map[io.Closer]struct{}{}},
+		dedupSaved:   &atomic.Int64{},
+	}
+
+	// Pipe write cannot be deduplicated, file created on open as without dedup
+	file, err := gdrive.OpenFile("file.bin", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	} else if !created {
+		t.Errorf("file not created on open")
+	}
+	if _, err = file.Write([]byte("Google drive")); err != nil {
+		t.Fatal(err)
+	} else if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
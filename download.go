@@ -0,0 +1,209 @@
+package drivefs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+const (
+	DefaultConnections   = 4        // Concurrent range requests of DownloadTo
+	DefaultDownloadChunk = 16 << 20 // Range size of DownloadTo
+	parallelMinChunk     = 1 << 20  // Smaller ranges are not split in read path
+)
+
+// Options to DownloadTo
+type DownloadOptions struct {
+	Connections int    // Concurrent range requests, default DefaultConnections
+	ChunkSize   int64  // Range size, default DefaultDownloadChunk
+	Sidecar     string // File to record ranges downloaded, download resume from it if exists
+}
+
+// Ranges downloaded, saved in sidecar file
+type downloadState struct {
+	ID        string  `json:"id"`
+	Revision  string  `json:"revision"`
+	Size      int64   `json:"size"`
+	ChunkSize int64   `json:"chunk_size"`
+	Done      []int64 `json:"done"` // Start of ranges written
+}
+
+// Load sidecar, ignore it if is from other file or revision
+func (state *downloadState) load(sidecar string) {
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		return
+	}
+
+	var saved downloadState
+	if json.Unmarshal(data, &saved) == nil && saved.ID == state.ID && saved.Revision == state.Revision && saved.Size == state.Size && saved.ChunkSize == state.ChunkSize {
+		state.Done = saved.Done
+	}
+}
+
+// Write sidecar and rename to not have incomplete file on crash
+func (state *downloadState) save(sidecar string) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(sidecar), "."+filepath.Base(sidecar)+".tmp")
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sidecar)
+}
+
+// Download ranges of chunk size, until end, starting in starts with connections concurrent streams.
+//
+// fetched is called with range content, can be called in parallel
+func (file *FileNode) fetchRanges(ctx context.Context, starts []int64, chunk, end int64, connections int, fetched func(start int64, data []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wait sync.WaitGroup
+	var once sync.Once
+	var fail error
+	queue := make(chan int64)
+	for range max(1, connections) {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for start := range queue {
+				data, err := file.fetchRange(ctx, start, min(start+chunk, end))
+				if err == nil {
+					err = fetched(start, data)
+				}
+				if err != nil {
+					once.Do(func() { fail = err; cancel() })
+					return
+				}
+			}
+		}()
+	}
+
+send:
+	for _, start := range starts {
+		select {
+		case queue <- start:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wait.Wait()
+
+	if fail == nil {
+		fail = ctx.Err()
+	}
+	return fail
+}
+
+// Download range from start to end in one stream
+func (file *FileNode) fetchRange(ctx context.Context, start, end int64) ([]byte, error) {
+	stream, err := file.openStream(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.body.Close()
+
+	data := make([]byte, end-start)
+	if _, err = file.readFull(stream, data); err != nil {
+		return nil, ProcessErr(nil, err)
+	}
+	return data, nil
+}
+
+// Read p splited in ranges downloaded in parallel with Gdrive.Connections streams
+func (file *FileNode) readParallel(p []byte, off int64) (n int, err error) {
+	end := min(off+int64(len(p)), file.Node.Size)
+	chunk := max(parallelMinChunk, (end-off)/int64(file.Client.Connections))
+	starts := []int64{}
+	for start := off; start < end; start += chunk {
+		starts = append(starts, start)
+	}
+
+	err = file.fetchRanges(context.Background(), starts, chunk, end, file.Client.Connections, func(start int64, data []byte) error {
+		copy(p[start-off:], data)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	} else if n = int(end - off); n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Download file to w with concurrent range requests.
+//
+// With options.Sidecar ranges written are saved to continue download if interrupted
+func (gdrive *Gdrive) DownloadTo(ctx context.Context, name string, w io.WriterAt, options *DownloadOptions) (int64, error) {
+	name = pathManipulate(name).CleanPath()
+	node, err := gdrive.getNode(name)
+	if err != nil {
+		return 0, &fs.PathError{Op: "download", Path: name, Err: ProcessErr(fileRes(node), err)}
+	} else if node.MimeType == GoogleDriveMimeFolder {
+		return 0, &fs.PathError{Op: "download", Path: name, Err: fs.ErrInvalid}
+	} else if gdrive.isWorkspace(node) {
+		doc, err := gdrive.openWorkspace(name, node)
+		if err != nil {
+			return 0, err
+		}
+		defer doc.Close()
+		return doc.WriteTo(io.NewOffsetWriter(w, 0))
+	} else if err = gdrive.ensureCreated(node.Id); err != nil {
+		return 0, &fs.PathError{Op: "download", Path: name, Err: err}
+	}
+
+	opts := DownloadOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Connections <= 0 {
+		opts.Connections = DefaultConnections
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultDownloadChunk
+	}
+
+	file := &FileNode{Client: gdrive, Node: node, name: name}
+	state := &downloadState{ID: node.Id, Revision: file.blockKey(), Size: node.Size, ChunkSize: opts.ChunkSize, Done: []int64{}}
+	if opts.Sidecar != "" {
+		state.load(opts.Sidecar)
+	}
+
+	starts := []int64{}
+	for start := int64(0); start < node.Size; start += opts.ChunkSize {
+		if !slices.Contains(state.Done, start) {
+			starts = append(starts, start)
+		}
+	}
+
+	var locker sync.Mutex
+	err = file.fetchRanges(ctx, starts, opts.ChunkSize, node.Size, opts.Connections, func(start int64, data []byte) error {
+		if _, err := w.WriteAt(data, start); err != nil {
+			return err
+		} else if opts.Sidecar == "" {
+			return nil
+		}
+
+		locker.Lock()
+		defer locker.Unlock()
+		state.Done = append(state.Done, start)
+		return state.save(opts.Sidecar)
+	})
+	if err != nil {
+		return 0, &fs.PathError{Op: "download", Path: name, Err: err}
+	}
+
+	if opts.Sidecar != "" {
+		os.Remove(opts.Sidecar)
+	}
+	return node.Size, nil
+}
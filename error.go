@@ -1,6 +1,7 @@
 package drivefs
 
 import (
+	"errors"
 	"io/fs"
 	"net/http"
 	"net/url"
@@ -11,6 +12,13 @@ import (
 	"google.golang.org/api/googleapi"
 )
 
+var (
+	ErrChecksum = errors.New("uploaded content checksum not match")   // Google Drive checksum is different from content uploaded
+	ErrConflict = errors.New("remote file changed")                   // Remote file changed since write handle opened
+	ErrLocked   = errors.New("file locked by other client")           // Lock blocked by lock from other client
+	ErrCorrupt  = errors.New("downloaded content checksum not match") // Content read is different from Google Drive checksum
+)
+
 // Process response error and return equivalent to fs or os error
 func ProcessErr(res *googleapi.ServerResponse, err error) error {
 	if res != nil {
@@ -35,10 +43,13 @@ func ProcessErr(res *googleapi.ServerResponse, err error) error {
 		err = v.Err
 	case *apierror.APIError:
 		if details := v.Details(); details.QuotaFailure != nil {
-			return fs.ErrPermission
+			return &QuotaError{Reason: "quotaExceeded"}
 		}
 		return ProcessErr(&googleapi.ServerResponse{HTTPStatusCode: v.HTTPCode(), Header: nil}, v.Unwrap())
 	case *googleapi.Error:
+		if quota := quotaError(v); quota != nil {
+			return quota
+		}
 		return ProcessErr(&googleapi.ServerResponse{HTTPStatusCode: v.Code, Header: v.Header}, v.Unwrap())
 	}
 
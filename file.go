@@ -1,24 +1,30 @@
 package drivefs
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"errors"
-	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path"
+	"slices"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
 	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/googleapi"
+	"sirherobrine23.com.br/Sirherobrine23/cgofuse/fuse/calls"
 )
 
 var (
 	_ fs.FileInfo = (*NodeStat)(nil)
 	_ File        = (*DirNode)(nil)
 	_ File        = (*FileNode)(nil)
+	_ File        = (*LocalFile)(nil)
+	_ File        = (*DocFile)(nil)
 
 	_ fs.FS         = (*Gdrive)(nil)
 	_ fs.StatFS     = (*Gdrive)(nil)
@@ -34,21 +40,37 @@ func (node *NodeStat) Sys() any    { return node.File }
 func (node NodeStat) Name() string { return pathManipulate(path.Clean(node.File.Name)).EscapeName() }
 func (node NodeStat) Size() int64  { return node.File.Size }
 func (node NodeStat) IsDir() bool  { return node.File.MimeType == GoogleDriveMimeFolder }
+
+// File type from mime type, permission from properties
 func (node NodeStat) Mode() fs.FileMode {
+	var fileType, perm fs.FileMode = 0, 0777
+	switch {
+	case node.File.MimeType == GoogleDriveMimeFolder:
+		fileType, perm = fs.ModeDir, 0666
+	case node.File.MimeType == GoogleDriveMimeSyslink:
+		return fs.ModeSymlink | 0777 // Link permission is not used
+	case slices.Contains(DriveMimes, node.File.MimeType):
+		perm = 0444 // Google Workspace file, read only
+	}
+
 	if mode, ok := node.File.Properties[UnixModeProperties]; ok {
 		if mod, err := strconv.ParseUint(mode, 10, 64); err == nil {
-			return fs.FileMode(mod)
+			perm = fs.FileMode(mod) &^ fs.ModeType
 		}
 	}
+	return fileType | perm
+}
 
-	switch node.File.MimeType {
-	case GoogleDriveMimeFolder:
-		return fs.ModeDir | 0666
-	case GoogleDriveMimeSyslink:
-		return fs.ModeSymlink | 0777
+// Owner user and group from properties, -1 if not set
+func (node NodeStat) Owner() (uid, gid int) {
+	uid, gid = -1, -1
+	if id, err := strconv.Atoi(node.File.Properties[UnixUidProperties]); err == nil {
+		uid = id
 	}
-
-	return 0777 | fs.ModeType
+	if id, err := strconv.Atoi(node.File.Properties[UnixGidProperties]); err == nil {
+		gid = id
+	}
+	return
 }
 
 func (node NodeStat) ModTime() time.Time {
@@ -88,10 +110,37 @@ type FileNode struct {
 	Client *Gdrive
 
 	Offset int64 // Offset
+
+	name    string         // File path in Client
+	options *CreateOptions // Upload options
+	upload  *pendingUpload // Upload started by WriteAt
+	ahead   *readAhead     // Read-ahead, nil if disabled
+	streams *rangeStreams  // Download streams, nil if write only
+	locker  sync.Mutex     // Read offset
+	verify  *uploadHash    // Hash of content read, nil if not verify reads
+	hashed  int64          // Bytes hashed in verify, -1 if Read skipped content
+	limit   tokenBucket    // Download rate limit of this file
 }
 
+// Local copy of remote file, Read and Write in any offset and upload on Sync/Close
 type LocalFile struct {
-	*os.File // Local file append to struct
+	*os.File             // Local file append to struct
+	Node     *drive.File // Remote node
+	Client   *Gdrive
+
+	name     string         // File path in Client
+	options  *CreateOptions // Upload options
+	journal  *JournalEntry  // Write-back journal entry, upload by journal worker
+	dirty    bool           // Local file changed since last upload
+	readOnly bool           // Content from journal opened to read
+}
+
+// Google Workspace file exported or pointer file, read only
+type DocFile struct {
+	*bytes.Reader             // Exported content
+	Node          *drive.File // Remote node, size is content size
+
+	name string // File path in Client
 }
 
 func (*DirNode) Sync() error                                    { return nil }
@@ -145,105 +194,226 @@ func (dir *DirNode) ReadDir(count int) ([]fs.DirEntry, error) {
 	return convertDriveToDir(dir.Files[dir.Offset : dir.Offset+min]), nil
 }
 
-func (*FileNode) Sync() error                              { return nil }
 func (*FileNode) ReadDir(count int) ([]fs.DirEntry, error) { return nil, fs.ErrInvalid }
 func (*FileNode) Truncate(size int64) error                { return syscall.ECONNREFUSED }
 
 func (file *FileNode) Stat() (fs.FileInfo, error) { return &NodeStat{File: file.Node}, nil }
 func (file *FileNode) Close() error {
+	if file.ahead != nil {
+		file.ahead.stop()
+	}
+	if file.streams != nil {
+		file.streams.close()
+	}
+	err := file.Sync()
+	if file.Client != nil && file.upload != nil {
+		file.Client.tracker.remove(file)
+	}
+
 	var closed io.Closer
 	switch {
 	case file.Reader != nil:
 		closed = file.Reader
 	case file.Writer != nil:
 		closed = file.Writer
-	default:
-		return nil // nothing to close
 	}
 	file.Offset = -1
 	file.Reader = nil
 	file.Writer = nil
 	file.Client = nil
-	return closed.Close()
+	if closed != nil {
+		err = errors.Join(err, closed.Close())
+	}
+	return err
 }
 
-func (file *FileNode) Read(p []byte) (int, error)        { return file.ReadAt(p, file.Offset) }
+// Wait upload be confirmed by Google Drive and update Node
+func (file *FileNode) Sync() error {
+	if file.upload == nil {
+		if file.Writer == nil || file.Reader == nil || file.Node.Id != "" {
+			return nil // Read only or not written
+		}
+		file.startUpload() // Create empty file
+	}
+
+	if file.Writer != nil {
+		file.Writer.Close() // Send EOF to upload
+		file.Writer = nil
+	}
+
+	<-file.upload.done
+	if file.upload.err != nil {
+		return &fs.PathError{Op: "sync", Path: file.name, Err: file.upload.err}
+	}
+
+	file.Node = file.upload.node
+	if file.Client != nil {
+		file.Node = file.Client.cacheUploaded(path.Join(file.Client.SubDir, file.name), file.Node)
+	}
+	return nil
+}
+
+// Read from offset and move offset
+func (file *FileNode) Read(p []byte) (n int, err error) {
+	file.locker.Lock()
+	defer file.locker.Unlock()
+	off := file.Offset
+	n, err = file.ReadAt(p, off)
+	file.Offset += int64(n)
+	if file.verify != nil {
+		err = file.verifyRead(p[:n], off, err)
+	}
+	return
+}
+
+// Hash content read in sequence, on EOF compare with Google Drive checksums
+func (file *FileNode) verifyRead(p []byte, off int64, err error) error {
+	if off == 0 && file.hashed != 0 {
+		file.verify, file.hashed = newUploadHash(), 0 // Read again from start
+	}
+	if off != file.hashed {
+		file.hashed = -1 // Seek, content not verified
+		return err
+	}
+
+	file.verify.Write(p)
+	if file.hashed += int64(len(p)); err == io.EOF && file.hashed == file.Node.Size {
+		if check := file.verify.compare(file.Node, ErrCorrupt); check != nil {
+			return &fs.PathError{Op: "read", Path: file.name, Err: check}
+		}
+	}
+	return err
+}
 func (file *FileNode) Write(p []byte) (n int, err error) { return file.WriteAt(p, file.Offset) }
 
-// Update offset
+// Update offset, next Read open stream in new offset
 func (file *FileNode) Seek(offset int64, whence int) (int64, error) {
-	if file.Reader == nil || file.Writer == nil || file.Client == nil || file.Offset < 0 {
-		return 0, io.EOF
+	if file.Client == nil || file.Offset < 0 {
+		return 0, fs.ErrClosed
 	}
 
+	file.locker.Lock()
+	defer file.locker.Unlock()
 	switch whence {
 	case io.SeekStart:
-		if offset < 0 {
-			return 0, fs.ErrInvalid
-		} else if offset > file.Node.Size {
-			return 0, io.ErrUnexpectedEOF
-		}
-		file.Offset = offset
 	case io.SeekCurrent:
-		offset = file.Offset + offset
-		if offset > file.Node.Size || offset < 0 {
-			return 0, io.ErrUnexpectedEOF
-		}
-		file.Offset += offset
+		offset += file.Offset
 	case io.SeekEnd:
-		if offset > 0 {
-			offset = ^offset
-		}
-		file.Offset = file.Node.Size + offset
+		offset += file.Node.Size
 	default:
-		return 0, &fs.PathError{Op: "seek", Path: file.Node.Id, Err: fs.ErrInvalid}
+		return 0, &fs.PathError{Op: "seek", Path: file.name, Err: fs.ErrInvalid}
 	}
 
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: file.name, Err: fs.ErrInvalid}
+	}
+	file.Offset = offset
 	return file.Offset, nil
 }
 
-// Read is Seek and Read in same function
+// Read from off without change Read offset, safe to call in parallel
 func (file *FileNode) ReadAt(p []byte, off int64) (n int, err error) {
-	if file.Writer == nil && file.Reader == nil {
-		return 0, io.EOF
-	} else if file.Writer != nil && file.Reader == nil {
+	if file.Client == nil {
+		return 0, fs.ErrClosed
+	} else if file.streams == nil {
+		return 0, fs.ErrInvalid // Write only
+	} else if off < 0 {
 		return 0, fs.ErrInvalid
-	} else if off < 0 || off > file.Node.Size {
-		return 0, io.ErrUnexpectedEOF
+	} else if off >= file.Node.Size {
+		return 0, io.EOF
 	}
 
-	switch min(1, max(-1, file.Offset-off)) {
-	case 1: // Discart next reader
-		if _, err = io.CopyN(io.Discard, file.Reader, file.Offset-off); err != nil {
-			return 0, ProcessErr(nil, err)
+	if file.ahead != nil {
+		var ok bool
+		if n, ok, err = file.ahead.read(p, off); ok {
+			return
 		}
-		file.Offset = off
-	case -1: // Restart body reader
-		// Set current offset off file
-		reopenFile := file.Client.driveService.Files.Get(file.Node.Id)
-		reopenFile.Header().Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
 
-		res, err := openFileAPI(reopenFile)
-		if err != nil {
-			return 0, ProcessErr(httpRes(res), err)
+	// Read rest not served by read-ahead
+	var rest int
+	p, off = p[n:], off+int64(n)
+	if file.Client.blocks != nil && file.blockKey() != "" {
+		rest, err = file.readBlocks(p, off)
+	} else if file.Client.Connections > 1 && int64(len(p)) >= 2*parallelMinChunk {
+		rest, err = file.readParallel(p, off)
+	} else {
+		rest, err = file.readStream(p, off)
+	}
+	return n + rest, err
+}
+
+// Key of node content in block cache, empty if node not have revision
+func (file *FileNode) blockKey() string {
+	if revision := cmp.Or(file.Node.HeadRevisionId, file.Node.Md5Checksum); revision != "" {
+		return file.Node.Id + ":" + revision
+	}
+	return ""
+}
+
+// Read from block cache, blocks missing are fetched in one range request
+func (file *FileNode) readBlocks(p []byte, off int64) (n int, err error) {
+	blocks, key := file.Client.blocks, file.blockKey()
+	end := min(off+int64(len(p)), file.Node.Size)
+	for index := off / blocks.BlockSize; off+int64(n) < end; {
+		data := [][]byte{blocks.Get(key, index)}
+		if data[0] == nil {
+			last := index
+			for (last+1)*blocks.BlockSize < end && !blocks.Has(key, last+1) {
+				last++
+			}
+			if data, err = file.fetchBlocks(key, index, last); err != nil {
+				return n, err
+			}
 		}
 
-		// Close if opened header
-		if file.Reader != nil {
-			file.Reader.Close()
+		for _, block := range data {
+			n += copy(p[n:], block[off+int64(n)-index*blocks.BlockSize:])
+			index++
 		}
+	}
 
-		// Add to Reader field
-		file.Reader = res.Body
-		file.Offset = off
+	if n < len(p) {
+		err = io.EOF
 	}
+	return n, err
+}
 
-	n, err = file.Reader.Read(p)
-	file.Offset += int64(n)
-	if err == io.EOF {
-		file.Close()
+// Download blocks from first to last and save in block cache
+func (file *FileNode) fetchBlocks(key string, first, last int64) ([][]byte, error) {
+	blocks := file.Client.blocks
+	start, end := first*blocks.BlockSize, min((last+1)*blocks.BlockSize, file.Node.Size)
+
+	stream, err := file.openStream(context.Background(), start, end)
+	if err != nil {
+		return nil, err
 	}
-	return
+	defer stream.body.Close()
+
+	data := [][]byte{}
+	for ; start < end; start += blocks.BlockSize {
+		block := make([]byte, min(blocks.BlockSize, end-start))
+		if _, err = file.readFull(stream, block); err != nil {
+			return nil, ProcessErr(nil, err)
+		}
+		blocks.Set(key, start/blocks.BlockSize, block)
+		data = append(data, block)
+	}
+	return data, nil
+}
+
+// Start upload in background reading from pipe
+func (file *FileNode) startUpload() {
+	session := file.Client.newSession(file.Node, file.options)
+	if session.FileID != "" && session.expect != nil && file.Client.ConflictPolicy == ConflictCopy {
+		// Pipe cannot be read again on commit, upload to conflicted copy if remote already changed
+		if err := file.Client.checkConflict(session.expect); errors.Is(err, ErrConflict) {
+			conflictSession(session, file.Node)
+		}
+	}
+	file.upload = file.Client.uploadBackground(session, file.Reader)
+	file.Client.tracker.add(file)
+	file.Reader = nil // Remove from struct
 }
 
 func (file *FileNode) WriteAt(p []byte, off int64) (n int, err error) {
@@ -252,8 +422,7 @@ func (file *FileNode) WriteAt(p []byte, off int64) (n int, err error) {
 	}
 
 	if file.Writer != nil && file.Reader != nil {
-		go file.Client.driveService.Files.Update(file.Node.Id, nil).Media(file.Reader, googleapi.ContentType("application/octet-stream")).Do()
-		file.Reader = nil // Remove from struct
+		file.startUpload()
 	}
 
 	if file.Writer == nil && file.Reader != nil {
@@ -278,3 +447,197 @@ func (file *FileNode) WriteAt(p []byte, off int64) (n int, err error) {
 	// return invalid error
 	return 0, errors.Join(errors.New("WriteAt not support to change offset"), fs.ErrInvalid)
 }
+
+// Download node content to temporary file and return [*LocalFile].
+//
+// With write-back journal file is created in journal directory and uploaded by journal worker
+func (gdrive *Gdrive) openLocal(name string, node *drive.File, flag int, options *CreateOptions) (_ *LocalFile, err error) {
+	var tmpFile *os.File
+	var entry, previous *JournalEntry
+	if gdrive.journal != nil {
+		previous = gdrive.journal.find(path.Join(gdrive.SubDir, name))
+		entry, tmpFile, err = gdrive.journal.create(path.Join(gdrive.SubDir, name), node, options)
+	} else {
+		tmpFile, err = os.CreateTemp("", "drivefs-*")
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			if entry != nil {
+				gdrive.journal.remove(entry)
+			} else {
+				os.Remove(tmpFile.Name())
+			}
+		}
+	}()
+
+	local := &LocalFile{File: tmpFile, Node: node, Client: gdrive, name: name, options: options, journal: entry}
+	defer func() {
+		if err == nil {
+			gdrive.tracker.add(local)
+		}
+	}()
+	if node.Id == "" {
+		local.dirty = true // Create file on close
+	} else if calls.OpenFlags(flag).Includes(os.O_TRUNC) {
+		local.dirty = node.Size > 0 // Upload empty content on close
+	} else if previous != nil {
+		// Continue from content not uploaded
+		previousFile, err := os.Open(previous.LocalFile)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		defer previousFile.Close()
+
+		if _, err = io.Copy(tmpFile, previousFile); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		} else if _, err = tmpFile.Seek(0, io.SeekStart); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		local.dirty = true
+	} else if node.Size > 0 {
+		res, err := openFileAPI(gdrive.driveService.Files.Get(node.Id))
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: ProcessErr(httpRes(res), err)}
+		}
+		defer res.Body.Close()
+
+		if _, err = io.Copy(tmpFile, &throttledReader{ReadCloser: res.Body, client: gdrive, file: &tokenBucket{}}); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: ProcessErr(nil, err)}
+		} else if _, err = tmpFile.Seek(0, io.SeekStart); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	// Reopen file to kernel append writes
+	if calls.OpenFlags(flag).Includes(os.O_APPEND) {
+		if local.File, err = os.OpenFile(tmpFile.Name(), os.O_RDWR|os.O_APPEND, 0600); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		tmpFile.Close()
+	}
+
+	return local, nil
+}
+
+// Open content waiting upload in write-back journal to read
+func (gdrive *Gdrive) openJournalFile(name string, entry *JournalEntry) (*LocalFile, error) {
+	file, err := os.Open(entry.LocalFile)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &LocalFile{File: file, Node: entry.Node, Client: gdrive, name: name, readOnly: true}, nil
+}
+
+func (*LocalFile) ReadDir(count int) ([]fs.DirEntry, error) { return nil, fs.ErrInvalid }
+
+func (local *LocalFile) Stat() (fs.FileInfo, error) {
+	info, err := local.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	node := *local.Node
+	node.Size = info.Size()
+	return &NodeStat{File: &node}, nil
+}
+
+func (local *LocalFile) Write(p []byte) (int, error) {
+	local.dirty = true
+	return local.File.Write(p)
+}
+
+func (local *LocalFile) WriteAt(p []byte, off int64) (int, error) {
+	local.dirty = true
+	return local.File.WriteAt(p, off)
+}
+
+func (local *LocalFile) WriteString(s string) (int, error) {
+	local.dirty = true
+	return local.File.WriteString(s)
+}
+
+func (local *LocalFile) ReadFrom(r io.Reader) (int64, error) {
+	local.dirty = true
+	return local.File.ReadFrom(r)
+}
+
+func (local *LocalFile) Truncate(size int64) error {
+	local.dirty = true
+	return local.File.Truncate(size)
+}
+
+// Upload local file as new revision if changed, with write-back only save to disk
+func (local *LocalFile) Sync() error {
+	if !local.dirty || local.readOnly {
+		return nil
+	} else if local.journal != nil {
+		if err := local.File.Sync(); err != nil {
+			return &fs.PathError{Op: "sync", Path: local.name, Err: err}
+		}
+		return local.Client.journal.save(local.journal)
+	}
+
+	info, err := local.File.Stat()
+	if err != nil {
+		return &fs.PathError{Op: "sync", Path: local.name, Err: err}
+	}
+
+	node, err := local.Client.upload(local.Client.newSession(local.Node, local.options), io.NewSectionReader(local.File, 0, info.Size()))
+	if err != nil {
+		return &fs.PathError{Op: "sync", Path: local.name, Err: err}
+	}
+
+	local.Node, local.dirty = local.Client.cacheUploaded(path.Join(local.Client.SubDir, local.name), node), false
+	return nil
+}
+
+// Upload changes and remove local file, with write-back send file to journal worker
+func (local *LocalFile) Close() error {
+	if local.readOnly {
+		return local.File.Close()
+	} else if local.journal != nil {
+		return local.closeJournal()
+	}
+
+	err := local.Sync()
+	local.Client.tracker.remove(local)
+	if closeErr := local.File.Close(); closeErr != nil {
+		return errors.Join(err, closeErr)
+	}
+	return errors.Join(err, os.Remove(local.File.Name()))
+}
+
+// Close file and mark journal entry to upload
+func (local *LocalFile) closeJournal() error {
+	local.Client.tracker.remove(local)
+	if err := errors.Join(local.File.Sync(), local.File.Close()); err != nil {
+		return &fs.PathError{Op: "close", Path: local.name, Err: err}
+	} else if !local.dirty {
+		return local.Client.journal.remove(local.journal)
+	}
+
+	local.journal.State = JournalPending
+	if err := local.Client.journal.save(local.journal); err != nil {
+		return &fs.PathError{Op: "close", Path: local.name, Err: err}
+	}
+	local.Client.journal.notify()
+	return nil
+}
+
+func (*DocFile) ReadDir(count int) ([]fs.DirEntry, error) { return nil, fs.ErrInvalid }
+func (*DocFile) Sync() error                              { return nil }
+
+func (doc *DocFile) Stat() (fs.FileInfo, error) { return &NodeStat{File: doc.Node}, nil }
+func (doc *DocFile) Close() error               { doc.Reset(nil); return nil }
+func (doc *DocFile) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: doc.name, Err: fs.ErrPermission}
+}
+func (doc *DocFile) WriteAt([]byte, int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: doc.name, Err: fs.ErrPermission}
+}
+func (doc *DocFile) Truncate(int64) error {
+	return &fs.PathError{Op: "truncate", Path: doc.name, Err: fs.ErrPermission}
+}
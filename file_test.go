@@ -0,0 +1,223 @@
+package drivefs
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestFileNodeSeek(t *testing.T) {
+	file := &FileNode{Client: &Gdrive{}, Node: &drive.File{Size: 100}, streams: &rangeStreams{}}
+	for _, seek := range []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{10, io.SeekStart, 10},
+		{5, io.SeekCurrent, 15},
+		{-20, io.SeekEnd, 80},
+		{0, io.SeekEnd, 100},
+		{-100, io.SeekCurrent, 0},
+	} {
+		if offset, err := file.Seek(seek.offset, seek.whence); err != nil {
+			t.Errorf("cannot seek %d %d: %s", seek.offset, seek.whence, err)
+		} else if offset != seek.want {
+			t.Errorf("invalid offset: %d != %d", offset, seek.want)
+		}
+	}
+
+	if _, err := file.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("negative offset accepted")
+	} else if _, err = file.Seek(10, io.SeekEnd); err != nil {
+		t.Errorf("cannot seek after end: %s", err)
+	} else if n, err := file.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("read after end: %d, %v", n, err)
+	}
+}
+
+func TestFileNodeVerifyRead(t *testing.T) {
+	for _, content := range []struct {
+		data string
+		want error
+	}{
+		{"Google drive", io.EOF},
+		{"Google Drive", ErrCorrupt},
+	} {
+		file := &FileNode{Node: &drive.File{Size: 12}, verify: newUploadHash()}
+		sum := newUploadHash()
+		sum.Write([]byte("Google drive"))
+		file.Node.Md5Checksum = hex.EncodeToString(sum.md5.Sum(nil))
+
+		if err := file.verifyRead([]byte(content.data[:6]), 0, nil); err != nil {
+			t.Errorf("error before EOF: %s", err)
+		} else if err = file.verifyRead([]byte(content.data[6:]), 6, io.EOF); !errors.Is(err, content.want) {
+			t.Errorf("%q: %v, expected %v", content.data, err, content.want)
+		}
+	}
+}
+
+func TestFileNodeReadStreamSkip(t *testing.T) {
+	content := make([]byte, 100)
+	for index := range content {
+		content[index] = byte(index)
+	}
+
+	file := &FileNode{Client: &Gdrive{}, Node: &drive.File{Size: 100}, streams: &rangeStreams{}}
+	file.streams.put(&rangeStream{ctx: context.Background(), body: io.NopCloser(bytes.NewReader(content))})
+	for _, off := range []int64{10, 20, 45} {
+		p := make([]byte, 10)
+		if n, err := file.ReadAt(p, off); err != nil || n != 10 {
+			t.Fatalf("read at %d: %d, %v", off, n, err)
+		} else if !bytes.Equal(p, content[off:off+10]) {
+			t.Errorf("read at %d returned bytes from %d", off, p[0])
+		}
+	}
+}
+
+func TestFileNodeParallelReadAt(t *testing.T) {
+	content := make([]byte, 3*ReadAheadBlock+123)
+	for index := range content {
+		content[index] = byte(index % 251)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	for _, readAhead := range []int64{0, 2 * ReadAheadBlock} {
+		gdrive := &Gdrive{driveService: service, ReadAhead: readAhead}
+		file := &FileNode{Client: gdrive, Node: &drive.File{Id: "file", Size: int64(len(content))}, streams: &rangeStreams{}}
+		if readAhead > 0 {
+			file.ahead = gdrive.newReadAhead(file)
+		}
+
+		// Sequential runs from random offsets, read-ahead reset by other readers
+		var wait sync.WaitGroup
+		for reader := range 8 {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				random := rand.New(rand.NewPCG(uint64(reader), 0))
+				off := int64(0)
+				for read := range 40 {
+					if read%5 == 0 {
+						off = random.Int64N(int64(len(content)))
+					}
+					p := make([]byte, 1+random.IntN(64<<10))
+					n, err := file.ReadAt(p, off)
+					if err != nil && err != io.EOF {
+						t.Errorf("read at %d: %s", off, err)
+						return
+					} else if !bytes.Equal(p[:n], content[off:off+int64(n)]) {
+						t.Errorf("read at %d returned other bytes", off)
+						return
+					} else if n < len(p) && off+int64(n) != int64(len(content)) {
+						t.Errorf("short read at %d: %d of %d", off, n, len(p))
+						return
+					}
+					if off += int64(n); off >= int64(len(content)) {
+						off = 0
+					}
+				}
+			}()
+		}
+		wait.Wait()
+		file.Close()
+	}
+}
+
+func TestLocalFileReadWrite(t *testing.T) {
+	var locker sync.Mutex
+	content, uploads := []byte("Hello world"), 0
+	node := &drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Parents: []string{"root"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locker.Lock()
+		defer locker.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("alt") == "media":
+			http.ServeContent(w, r, "file.txt", time.Time{}, bytes.NewReader(content))
+		case r.Method == http.MethodGet:
+			node.Size = int64(len(content))
+			json.NewEncoder(w).Encode(&drive.FileList{Files: []*drive.File{node}})
+		case r.Method == http.MethodPatch:
+			w.Header().Set("Location", "http://"+r.Host+"/session")
+		case r.Method == http.MethodPut:
+			content, _ = io.ReadAll(r.Body)
+			uploads++
+			json.NewEncoder(w).Encode(node)
+		}
+	}))
+	defer server.Close()
+
+	service, client := testService(t, server)
+	gdrive := &Gdrive{client: client, driveService: service, rootDrive: &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder}, tracker: &uploadTracker{files: map[io.Closer]struct{}{}}}
+	check := func(want string, wantUploads int) {
+		t.Helper()
+		locker.Lock()
+		defer locker.Unlock()
+		if string(content) != want || uploads != wantUploads {
+			t.Errorf("content %q after %d uploads, expected %q after %d", content, uploads, want, wantUploads)
+		}
+	}
+
+	// Read and write at any offset, truncate
+	file, err := gdrive.OpenFile("file.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := file.(*LocalFile)
+	p := make([]byte, 5)
+	if n, err := local.ReadAt(p, 6); err != nil || string(p[:n]) != "world" {
+		t.Fatalf("read at 6: %q, %v", p[:n], err)
+	} else if _, err = local.WriteAt([]byte("W"), 6); err != nil {
+		t.Fatal(err)
+	} else if _, err = local.WriteAt([]byte("H"), 0); err != nil {
+		t.Fatal(err)
+	} else if err = local.Truncate(9); err != nil {
+		t.Fatal(err)
+	} else if err = local.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check("Hello Wor", 1)
+	if _, err = os.Stat(local.File.Name()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("local file not removed after close: %v", err)
+	}
+
+	// Append after content
+	if file, err = gdrive.OpenFile("file.txt", os.O_RDWR|os.O_APPEND, 0); err != nil {
+		t.Fatal(err)
+	} else if _, err = file.Write([]byte("ld")); err != nil {
+		t.Fatal(err)
+	} else if err = file.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	check("Hello World", 2)
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check("Hello World", 2) // Synced content not uploaded again
+
+	// Read only access not upload
+	if file, err = gdrive.OpenFile("file.txt", os.O_RDWR, 0); err != nil {
+		t.Fatal(err)
+	} else if data, err := io.ReadAll(file); err != nil || string(data) != "Hello World" {
+		t.Errorf("read %q, %v", data, err)
+	} else if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check("Hello World", 2)
+}
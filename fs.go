@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"google.golang.org/api/drive/v3"
@@ -27,15 +28,9 @@ func (gdrive *Gdrive) Sub(dir string) (fs.FS, error) {
 	if err != nil {
 		return nil, &fs.PathError{Op: "open", Path: dir, Err: ProcessErr(fileRes(nodeID), err)}
 	}
-	return &Gdrive{
-		GoogleConfig: gdrive.GoogleConfig,
-		GoogleToken:  gdrive.GoogleToken,
-		driveService: gdrive.driveService,
-		cache:        gdrive.cache,
-		cacheDir:     gdrive.cacheDir,
-		rootDrive:    nodeID,
-		SubDir:       path.Join(gdrive.SubDir),
-	}, nil
+	sub := *gdrive // Share client, upload sessions and write handles tracked by Close
+	sub.rootDrive = nodeID
+	return &sub, nil
 }
 
 func (gdrive *Gdrive) Statfs(_ string) (total, free uint64, err error) {
@@ -56,51 +51,73 @@ func (gdrive *Gdrive) ReadFile(name string) (b []byte, err error) {
 	f, err := gdrive.Open(name)
 	if err == nil {
 		defer f.Close()
+		if file, ok := f.(*FileNode); ok && gdrive.Connections > 1 {
+			b = make([]byte, file.Node.Size)
+			if _, err = file.ReadAt(b, 0); err == io.EOF {
+				err = nil
+			}
+			if err == nil && gdrive.VerifyReads {
+				sum := newUploadHash() // Parallel ranges are not hashed by Read
+				sum.Write(b)
+				if check := sum.compare(file.Node, ErrCorrupt); check != nil {
+					return nil, &fs.PathError{Op: "read", Path: name, Err: check}
+				}
+			}
+			return b, err
+		}
 		return io.ReadAll(f)
 	}
 	return nil, err
 }
 
 func (gdrive *Gdrive) Lstat(name string) (fs.FileInfo, error) {
+	name = pathManipulate(name).CleanPath()
 	fileNode, err := gdrive.getNode(name)
-	if err != nil {
+	if errors.Is(err, fs.ErrNotExist) && gdrive.journalEntry(name) != nil {
+		return &NodeStat{File: gdrive.journalNode(name, nil)}, nil // Created by upload waiting in journal
+	} else if err != nil {
 		return nil, err
 	}
-	return &NodeStat{File: fileNode}, nil
+	return &NodeStat{File: gdrive.journalNode(name, fileNode)}, nil
 }
 
 // Resolve path and return File or Folder Stat
 func (gdrive *Gdrive) Stat(name string) (fs.FileInfo, error) {
 	name = pathManipulate(name).CleanPath()
 	fileNode, err := gdrive.getNode(name)
-	if err != nil {
+	if errors.Is(err, fs.ErrNotExist) && gdrive.journalEntry(name) != nil {
+		return &NodeStat{File: gdrive.journalNode(name, nil)}, nil // Created by upload waiting in journal
+	} else if err != nil {
 		return nil, err
 	}
 
-	// Loop to check if is shortcut
-	for limit := 200_000; limit > 0 && fileNode.MimeType == GoogleDriveMimeSyslink; limit-- {
-		if fileNode, err = gdrive.driveService.Files.Get(fileNode.ShortcutDetails.TargetId).Do(); err != nil {
-			return nil, ProcessErr(nil, err)
+	// Follow shortcuts to target
+	if fileNode.MimeType == GoogleDriveMimeSyslink {
+		if fileNode, err = gdrive.followShortcut(fileNode); err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
 		}
+		return &NodeStat{File: fileNode}, nil
 	}
-
-	return &NodeStat{File: fileNode}, nil
+	return &NodeStat{File: gdrive.journalNode(name, fileNode)}, nil
 }
 
+// Return shortcut target relative to link folder, "gdrive:<ID>" if target is outside root
 func (gdrive *Gdrive) ReadLink(name string) (string, error) {
+	name = pathManipulate(name).CleanPath()
 	fileNode, err := gdrive.getNode(name)
 	if err != nil {
-		return "", err
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: ProcessErr(fileRes(fileNode), err)}
+	} else if fileNode.MimeType != GoogleDriveMimeSyslink || fileNode.ShortcutDetails == nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
 	}
 
-	// Loop to check if is shortcut
-	for limit := 200_000; limit > 0 && fileNode.MimeType == GoogleDriveMimeSyslink; limit-- {
-		if fileNode, err = gdrive.driveService.Files.Get(fileNode.ShortcutDetails.TargetId).Fields("*").Do(); err != nil {
-			return "", err
-		}
+	target, err := gdrive.forwardPathResolve(fileNode.ShortcutDetails.TargetId)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	} else if strings.HasPrefix(target, "gdrive:") {
+		return target, nil
 	}
-
-	return gdrive.forwardPathResolve(fileNode.Id)
+	return relativePath(path.Dir(name), target), nil
 }
 
 func fileRes(res *drive.File) *googleapi.ServerResponse {
@@ -133,7 +150,7 @@ func (gdrive *Gdrive) ReadDir(name string) ([]fs.DirEntry, error) {
 		}
 	}
 
-	return convertDriveToDir(files), nil
+	return convertDriveToDir(gdrive.journalChildren(name, files)), nil
 }
 
 func (gdrive *Gdrive) Mkdir(name string, perm fs.FileMode) (err error) {
@@ -147,12 +164,17 @@ func (gdrive *Gdrive) Mkdir(name string, perm fs.FileMode) (err error) {
 		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
 	}
 
-	node, err := gdrive.driveService.Files.Create(&drive.File{
-		Name:       name,
+	folderNode := &drive.File{
+		Name:       path.Base(name),
 		MimeType:   GoogleDriveMimeFolder,
 		Parents:    []string{rootNode.Id},
 		Properties: map[string]string{UnixModeProperties: strconv.Itoa(int(fs.ModeDir | perm))},
-	}).Fields("*").Do()
+	}
+	if _, deferred := gdrive.deferCreate(name, folderNode); deferred {
+		return nil
+	}
+
+	node, err := gdrive.driveService.Files.Create(folderNode).Fields("*").Do()
 	if err != nil {
 		err = ProcessErr(fileRes(node), err)
 	} else if gdrive.cache != nil {
@@ -170,7 +192,13 @@ func (gdrive *Gdrive) Remove(name string) error {
 		return &fs.PathError{Op: "mkdir", Path: name, Err: ProcessErr(nil, err)}
 	}
 
-	if err = gdrive.driveService.Files.Delete(node.Id).Do(); err != nil {
+	if dropped, err := gdrive.creates.drop(node.Id); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	} else if dropped {
+		// Not created in Google Drive
+	} else if err = gdrive.ensureCreated(node.Id); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	} else if err = gdrive.driveService.Files.Delete(node.Id).Do(); err != nil {
 		return &fs.PathError{Op: "mkdir", Path: name, Err: ProcessErr(nil, err)}
 	}
 
@@ -189,13 +217,26 @@ func (gdrive *Gdrive) Rename(oldName, newName string) error {
 	oldNode, err := gdrive.getNode(oldName)
 	if err != nil {
 		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: ProcessErr(fileRes(oldNode), err)}
+	} else if err = gdrive.ensureCreated(oldNode.Id); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	} else if err = gdrive.checkConflict(oldNode); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+
+	newBase := path.Base(newName)
+	if gdrive.isWorkspace(oldNode) {
+		ext, _ := gdrive.workspaceExt(oldNode)
+		newBase = strings.TrimSuffix(newBase, ext) // Extension is not in Google Workspace name
 	}
 
 	if path.Dir(oldName) == path.Dir(newName) {
-		res, err := gdrive.driveService.Files.Update(oldNode.Id, &drive.File{Name: path.Base(newName)}).Fields("*").Do()
+		res, err := gdrive.driveService.Files.Update(oldNode.Id, &drive.File{Name: newBase}).Fields("*").Do()
 		if err != nil {
 			return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: ProcessErr(fileRes(res), err)}
-		} else if gdrive.cache != nil {
+		} else if gdrive.isWorkspace(res) {
+			res = gdrive.workspaceNode(res)
+		}
+		if gdrive.cache != nil {
 			gdrive.cache.Delete(oldName)
 			gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, newName), res)
 		}
@@ -211,13 +252,20 @@ func (gdrive *Gdrive) Rename(oldName, newName string) error {
 		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: ProcessErr(fileRes(newRootNode), err)}
 	}
 
-	updateParent := gdrive.driveService.Files.Update(oldNode.Id, &drive.File{Name: path.Base(newName)}).Fields("*")
+	if err = gdrive.ensureCreated(newRootNode.Id); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+
+	updateParent := gdrive.driveService.Files.Update(oldNode.Id, &drive.File{Name: newBase}).Fields("*")
 	updateParent.RemoveParents(oldRootNode.Id).AddParents(newRootNode.Id)
 
 	res, err := updateParent.Do()
 	if err != nil {
 		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: ProcessErr(fileRes(res), err)}
-	} else if gdrive.cache != nil {
+	} else if gdrive.isWorkspace(res) {
+		res = gdrive.workspaceNode(res)
+	}
+	if gdrive.cache != nil {
 		gdrive.cache.Delete(oldName)
 		gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, newName), res)
 	}
@@ -225,17 +273,33 @@ func (gdrive *Gdrive) Rename(oldName, newName string) error {
 	return nil
 }
 
-func (gdrive *Gdrive) OpenFile(name string, flag int, perm fs.FileMode) (_ File, err error) {
-	name = pathManipulate(name).CleanPath()
+func (gdrive *Gdrive) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return gdrive.openFile(name, flag, perm, nil)
+}
 
-	// Ignore Read+Write open
-	if calls.OpenFlags(flag).Includes(os.O_RDWR) {
+// Create file and upload content with metadata from options
+func (gdrive *Gdrive) CreateWithOptions(name string, options CreateOptions) (File, error) {
+	if options.Flag == 0 {
+		options.Flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	}
+	if options.Perm == 0 {
+		options.Perm = 0666
+	}
+	if options.Convert && ImportFormats[gdrive.convertOptions(name, &options).MimeType] == "" {
 		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
 	}
+	return gdrive.openFile(name, options.Flag, options.Perm, &options)
+}
+
+func (gdrive *Gdrive) openFile(name string, flag int, perm fs.FileMode, options *CreateOptions) (_ File, err error) {
+	name = pathManipulate(name).CleanPath()
 
 	var driveNode *drive.File
 	if driveNode, err = gdrive.getNode(name); err != nil {
 		if errors.Is(err, fs.ErrNotExist) && !calls.OpenFlags(flag).Includes(flag, os.O_CREATE) {
+			if entry := gdrive.journalEntry(name); entry != nil && flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC) == 0 {
+				return gdrive.openJournalFile(name, entry) // Created by upload waiting in journal
+			}
 			err = ProcessErr(fileRes(driveNode), err)
 			return
 		}
@@ -245,19 +309,37 @@ func (gdrive *Gdrive) OpenFile(name string, flag int, perm fs.FileMode) (_ File,
 			return nil, &fs.PathError{Op: "open", Path: name, Err: ProcessErr(fileRes(driveNode), err)}
 		}
 
-		var fileMake drive.File
+		fileMake := options.metadata()
 		fileMake.Parents = []string{parentRoot.Id}
 		fileMake.Name = path.Base(name)
+		if perm != 0 {
+			fileMake.Properties[UnixModeProperties] = strconv.Itoa(int(perm))
+		}
 
 		if calls.OpenFlags(flag).Includes(flag, syscall.S_IFDIR, syscall.S_IFDIR, int(fs.ModeDir)) {
 			fileMake.MimeType = GoogleDriveMimeFolder
+		} else if options = gdrive.convertOptions(name, options); options != nil && options.Convert {
+			fileMake.MimeType = ImportFormats[options.MimeType]
+			fileMake.Name = convertName(fileMake.Name)
+			driveNode = fileMake // Create in upload to convert content
 		} else {
-			fileMake.MimeType = GoogleDriveMimeFile
+			if fileMake.MimeType == "" {
+				fileMake.MimeType = gdrive.detectMime(name, nil)
+			}
+			seekable := flag&os.O_RDWR != 0 || gdrive.journal != nil // Content in local file, pipe writes are not deduplicated
+			if gdrive.atomicMode(options) != AtomicNone || gdrive.Dedup != DedupNone && seekable {
+				driveNode = fileMake // Create only after upload, dedup can copy existing content
+			}
 		}
 
-		driveNode, err = gdrive.driveService.Files.Create(&fileMake).Fields("*").Do()
-		if err != nil {
-			return nil, &fs.PathError{Op: "open", Path: name, Err: ProcessErr(fileRes(driveNode), err)}
+		if driveNode == nil {
+			driveNode, _ = gdrive.deferCreate(name, fileMake)
+		}
+		if driveNode == nil {
+			driveNode, err = gdrive.driveService.Files.Create(fileMake).Fields("*").Do()
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: ProcessErr(fileRes(driveNode), err)}
+			}
 		}
 	}
 
@@ -265,6 +347,12 @@ func (gdrive *Gdrive) OpenFile(name string, flag int, perm fs.FileMode) (_ File,
 		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 	}
 
+	if driveNode.MimeType == GoogleDriveMimeSyslink {
+		if driveNode, err = gdrive.followShortcut(driveNode); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
 	if driveNode.MimeType == GoogleDriveMimeFolder {
 		fileList, err := gdrive.filesFromNode(driveNode.Id)
 		if err != nil {
@@ -278,20 +366,45 @@ func (gdrive *Gdrive) OpenFile(name string, flag int, perm fs.FileMode) (_ File,
 		}, nil
 	}
 
+	if gdrive.isWorkspace(driveNode) {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		return gdrive.openWorkspace(name, driveNode)
+	}
+
+	// Read+Write open work in local copy and upload on Sync/Close, with write-back all writes are local
+	writeOnly := flag&(os.O_WRONLY|os.O_CREATE|os.O_TRUNC) != 0 // Any write flag
+	if calls.OpenFlags(flag).Includes(os.O_RDWR) || (gdrive.journal != nil && writeOnly) {
+		return gdrive.openLocal(name, driveNode, flag, options)
+	} else if gdrive.journal != nil {
+		if entry := gdrive.journal.find(path.Join(gdrive.SubDir, name)); entry != nil {
+			return gdrive.openJournalFile(name, entry)
+		}
+	}
+
 	fipe := &FileNode{
 		Client: gdrive,
 		Node:   driveNode,
 		Offset: 0,
+
+		name:    name,
+		options: options,
 	}
 
-	if calls.OpenFlags(flag).Includes(syscall.O_RDWR, syscall.O_WRONLY, syscall.O_CREAT, syscall.O_TRUNC) {
+	if writeOnly {
 		fipe.Reader, fipe.Writer = io.Pipe()
 	} else {
-		res, err := openFileAPI(gdrive.driveService.Files.Get(driveNode.Id))
-		if err != nil {
-			return nil, &fs.PathError{Op: "open", Path: name, Err: ProcessErr(fileRes(driveNode), err)}
+		if err = gdrive.ensureCreated(driveNode.Id); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		fipe.streams = &rangeStreams{} // Stream opened in first Read
+		if gdrive.ReadAhead > 0 {
+			fipe.ahead = gdrive.newReadAhead(fipe)
+		}
+		if gdrive.VerifyReads {
+			fipe.verify = newUploadHash()
 		}
-		fipe.Reader = res.Body
 	}
 
 	return fipe, nil
@@ -2,14 +2,20 @@ package drivefs
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -26,9 +32,17 @@ const (
 	GoogleDriveMimeFolder   string = "application/vnd.google-apps.folder"                // Folder mime type
 	GoogleDriveMimeSyslink  string = "application/vnd.google-apps.shortcut"              // Syslink mime type
 	GoogleDriveMimeFile     string = "application/octet-stream"                          // File stream mime type
+	GoogleDriveMimeDocument string = "application/vnd.google-apps.document"              // Google Docs mime type
+	GoogleDriveMimeSheet    string = "application/vnd.google-apps.spreadsheet"           // Google Sheets mime type
+	GoogleDriveMimeSlides   string = "application/vnd.google-apps.presentation"          // Google Slides mime type
 	UnixModeProperties      string = "unixMode"                                          // File permission properties
+	UnixUidProperties       string = "unixUid"                                           // File owner user properties
+	UnixGidProperties       string = "unixGid"                                           // File owner group properties
+	TempAppProperties       string = "drivefsTemp"                                       // Hidden node used to upload AtomicRename
+	GoogleUploadURL         string = "https://www.googleapis.com/upload/drive/v3/files"  // Resumable upload endpoint
 
 	DefaultCacheTime = time.Minute * 2
+	DefaultBlockSize = 1 << 20 // Block cache block size
 )
 
 // Google drive mime types
@@ -48,6 +62,24 @@ var DriveMimes = []string{
 	"application/vnd.google-apps.unknown",
 }
 
+// Google Workspace format to convert uploads, key is content mime type
+var ImportFormats = map[string]string{
+	"application/msword": GoogleDriveMimeDocument,
+	"application/rtf":    GoogleDriveMimeDocument,
+	"text/html":          GoogleDriveMimeDocument,
+	"text/plain":         GoogleDriveMimeDocument,
+	"application/vnd.oasis.opendocument.text":                                   GoogleDriveMimeDocument,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   GoogleDriveMimeDocument,
+	"application/vnd.ms-excel":                                                  GoogleDriveMimeSheet,
+	"text/csv":                                                                  GoogleDriveMimeSheet,
+	"text/tab-separated-values":                                                 GoogleDriveMimeSheet,
+	"application/vnd.oasis.opendocument.spreadsheet":                            GoogleDriveMimeSheet,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         GoogleDriveMimeSheet,
+	"application/vnd.ms-powerpoint":                                             GoogleDriveMimeSlides,
+	"application/vnd.oasis.opendocument.presentation":                           GoogleDriveMimeSlides,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": GoogleDriveMimeSlides,
+}
+
 type File interface {
 	fs.File
 	io.ReadWriteCloser
@@ -83,6 +115,7 @@ type FS interface {
 
 	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
 	Create(name string) (File, error)
+	CreateWithOptions(name string, options CreateOptions) (File, error)
 	ReadFile(name string) ([]byte, error)
 	ReadDir(name string) ([]fs.DirEntry, error)
 
@@ -90,44 +123,125 @@ type FS interface {
 	Remove(name string) error
 	Rename(oldName string, newName string) error
 
+	// Symlink creates shortcut link to target, target is path from root or "gdrive:<ID>"
+	Symlink(target, link string) error
+
+	// Chmod, Chown and Chtimes save mode and owner in properties and mtime in modifiedTime
+	Chmod(name string, mode fs.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chtimes(name string, atime, mtime time.Time) error
+
 	Sub(dir string) (fs.FS, error)
+
+	// Lock take advisory lock shared with others clients, renewed until Unlock
+	Lock(name string, exclusive bool, ttl time.Duration) error
+	Unlock(name string) error
+
+	// Close commit all open files, wait uploads to finish and release locks
+	Close() error
 }
 
 // Struct with implements [io/fs.FS]
 type Gdrive struct {
-	GoogleConfig *oauth2.Config `json:"client"`           // Google client app oauth project
-	GoogleToken  *oauth2.Token  `json:"token"`            // Authenticated user
-	SubDir       string         `json:"subdir,omitempty"` // Subdir to join in cache
-
-	driveService *drive.Service             // Google drive service
-	rootDrive    *drive.File                // Root to find files
-	cache        cache.Cache[*drive.File]   // Cache struct
-	cacheDir     cache.Cache[[]*drive.File] // Cache struct
+	GoogleConfig     *oauth2.Config          `json:"client"`                       // Google client app oauth project
+	GoogleToken      *oauth2.Token           `json:"token"`                        // Authenticated user
+	SubDir           string                  `json:"subdir,omitempty"`             // Subdir to join in cache
+	ChunkSize        int64                   `json:"chunk_size,omitempty"`         // Resumable upload chunk size
+	MimeDetector     MimeDetector            `json:"-"`                            // Override mime type detection of new files
+	ChecksumPolicy   ChecksumPolicy          `json:"checksum_policy,omitempty"`    // Action to upload with checksum not match
+	Atomic           AtomicMode              `json:"atomic,omitempty"`             // Replace content mode of write handles
+	ConflictPolicy   ConflictPolicy          `json:"conflict_policy,omitempty"`    // Action to remote file changed since open
+	Dedup            DedupMode               `json:"dedup,omitempty"`              // Copy or shortcut file with same content instead of upload, write-only handles without write-back upload all content
+	ReadAhead        int64                   `json:"read_ahead,omitempty"`         // Max bytes fetched in background after sequential reads, 0 to disable
+	ReadAheadStreams int                     `json:"read_ahead_streams,omitempty"` // Parallel range requests of read-ahead
+	Connections      int                     `json:"connections,omitempty"`        // Range requests in parallel to large reads, 0 or 1 to single stream
+	VerifyReads      bool                    `json:"verify_reads,omitempty"`       // Hash sequential reads and return ErrCorrupt on EOF if checksum not match
+	Workspace        WorkspaceMode           `json:"workspace,omitempty"`          // List Google Workspace files as exported or pointer files
+	ExportFormats    map[string]ExportFormat `json:"export_formats,omitempty"`     // Override ExportFormats, key is Google Workspace mime type
+	ConvertFolders   []string                `json:"convert_folders,omitempty"`    // Folders where new files are converted to Google Workspace from ImportFormats
+
+	client       *http.Client                // Authenticated http client
+	driveService *drive.Service              // Google drive service
+	sessions     cache.Cache[*UploadSession] // Resumable upload sessions
+	tracker      *uploadTracker              // Write handles and uploads in background
+	locks        *lockTable                  // Advisory locks held by client
+	journal      *journal                    // Write-back journal, nil if disabled
+	creates      *createQueue                // Deferred creates with pre-generated IDs, nil if disabled
+	quota        *quotaState                 // Uploads paused by quota
+	dedupSaved   *atomic.Int64               // Bytes not uploaded by Dedup
+	blocks       *cache.Blocks               // Disk cache of file blocks, nil if disabled
+	rate         *rateLimiter                // Upload and download rate limits
+	exportSizes  *exportSizes                // Size of exported Google Workspace files
+	lockOwner    string                      // Client ID in locks
+	rootDrive    *drive.File                 // Root to find files
+	cache        cache.Cache[*drive.File]    // Cache struct
+	cacheDir     cache.Cache[[]*drive.File]  // Cache struct
 }
 
 type AuthFn func(ctx context.Context, config *oauth2.Config) (token *oauth2.Token, err error)
 
 // GoogleOauthConfig represents google oauth token for drive setup
 type GoogleOauthConfig struct {
-	Client       string    `json:"client,omitempty"`        // installed.client_id
-	Secret       string    `json:"secret,omitempty"`        // installed.client_secret
-	Project      string    `json:"project,omitempty"`       // installed.project_id
-	AuthURI      string    `json:"auth_uri,omitempty"`      // installed.auth_uri
-	TokenURI     string    `json:"token_uri,omitempty"`     // installed.token_uri
-	Redirect     string    `json:"redirect,omitempty"`      // installed.redirect_uris[]
-	AccessToken  string    `json:"access_token,omitempty"`  // token.access_token
-	RefreshToken string    `json:"refresh_token,omitempty"` // token.refresh_token
-	TokenType    string    `json:"token_type,omitempty"`    // token.token_type
-	Expire       time.Time `json:"expire,omitzero"`         // token.expiry
-	RootFolder   string    `json:"root_folder,omitempty"`   // Google drive folder id (gdrive:<ID>) or path to folder
-	UserAuth     AuthFn    `json:"-"`                       // Function to auth user
+	Client           string                  `json:"client,omitempty"`             // installed.client_id
+	Secret           string                  `json:"secret,omitempty"`             // installed.client_secret
+	Project          string                  `json:"project,omitempty"`            // installed.project_id
+	AuthURI          string                  `json:"auth_uri,omitempty"`           // installed.auth_uri
+	TokenURI         string                  `json:"token_uri,omitempty"`          // installed.token_uri
+	Redirect         string                  `json:"redirect,omitempty"`           // installed.redirect_uris[]
+	AccessToken      string                  `json:"access_token,omitempty"`       // token.access_token
+	RefreshToken     string                  `json:"refresh_token,omitempty"`      // token.refresh_token
+	TokenType        string                  `json:"token_type,omitempty"`         // token.token_type
+	Expire           time.Time               `json:"expire,omitzero"`              // token.expiry
+	RootFolder       string                  `json:"root_folder,omitempty"`        // Google drive folder id (gdrive:<ID>) or path to folder
+	ChunkSize        int64                   `json:"chunk_size,omitempty"`         // Resumable upload chunk size, multiple of 256KiB
+	SessionDB        string                  `json:"session_db,omitempty"`         // Sqlite file to save resumable upload sessions
+	WriteBack        string                  `json:"write_back,omitempty"`         // Directory to save writes before upload, enable write-back
+	DeferCreate      bool                    `json:"defer_create,omitempty"`       // Return creates with pre-generated ID and send to Google Drive in background
+	BlockCache       string                  `json:"block_cache,omitempty"`        // Directory to cache downloaded blocks, can be shared between mounts
+	BlockCacheSize   int64                   `json:"block_cache_size,omitempty"`   // Max bytes in block cache, 0 to no limit
+	BlockSize        int64                   `json:"block_size,omitempty"`         // Block cache block size
+	ReadAhead        int64                   `json:"read_ahead,omitempty"`         // Max bytes fetched in background after sequential reads, 0 to disable
+	ReadAheadStreams int                     `json:"read_ahead_streams,omitempty"` // Parallel range requests of read-ahead
+	Connections      int                     `json:"connections,omitempty"`        // Range requests in parallel to large reads, 0 or 1 to single stream
+	VerifyReads      bool                    `json:"verify_reads,omitempty"`       // Hash sequential reads and return ErrCorrupt on EOF if checksum not match
+	RateLimit        RateLimit               `json:"rate_limit,omitzero"`          // Upload and download rate limits, change with SetRateLimit
+	Workspace        WorkspaceMode           `json:"workspace,omitempty"`          // List Google Workspace files as exported or pointer files
+	ExportFormats    map[string]ExportFormat `json:"export_formats,omitempty"`     // Override ExportFormats, key is Google Workspace mime type
+	ConvertFolders   []string                `json:"convert_folders,omitempty"`    // Folders where new files are converted to Google Workspace from ImportFormats
+	MimeDetector     MimeDetector            `json:"-"`                            // Override mime type detection of new files
+	ChecksumPolicy   ChecksumPolicy          `json:"checksum_policy,omitempty"`    // Action to upload with checksum not match
+	Atomic           AtomicMode              `json:"atomic,omitempty"`             // Replace content mode of write handles
+	ConflictPolicy   ConflictPolicy          `json:"conflict_policy,omitempty"`    // Action to remote file changed since open
+	Dedup            DedupMode               `json:"dedup,omitempty"`              // Copy or shortcut file with same content instead of upload, write-only handles without write-back upload all content
+	UserAuth         AuthFn                  `json:"-"`                            // Function to auth user
 }
 
 // Create new Gdrive struct and configure google drive client
 func NewGoogleDrive(config GoogleOauthConfig) (FS, error) {
 	gdrive := &Gdrive{
-		cache:    cache.NewMemory[*drive.File](),
-		cacheDir: cache.NewMemory[[]*drive.File](),
+		cache:       cache.NewMemory[*drive.File](),
+		cacheDir:    cache.NewMemory[[]*drive.File](),
+		sessions:    cache.NewMemory[*UploadSession](),
+		tracker:     &uploadTracker{files: map[io.Closer]struct{}{}},
+		locks:       &lockTable{held: map[string]*heldLock{}},
+		quota:       &quotaState{closed: make(chan struct{})},
+		dedupSaved:  &atomic.Int64{},
+		rate:        &rateLimiter{},
+		exportSizes: &exportSizes{},
+
+		ChunkSize:        config.ChunkSize,
+		MimeDetector:     config.MimeDetector,
+		ChecksumPolicy:   config.ChecksumPolicy,
+		Atomic:           config.Atomic,
+		ConflictPolicy:   config.ConflictPolicy,
+		Dedup:            config.Dedup,
+		ReadAhead:        config.ReadAhead,
+		ReadAheadStreams: config.ReadAheadStreams,
+		Connections:      config.Connections,
+		VerifyReads:      config.VerifyReads,
+		Workspace:        config.Workspace,
+		ExportFormats:    config.ExportFormats,
+		ConvertFolders:   config.ConvertFolders,
 
 		GoogleConfig: &oauth2.Config{
 			ClientID:     config.Client,
@@ -147,6 +261,13 @@ func NewGoogleDrive(config GoogleOauthConfig) (FS, error) {
 		},
 	}
 
+	if err := gdrive.SetRateLimit(config.RateLimit); err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	gdrive.lockOwner = fmt.Sprintf("%s-%d-%s", host[:min(len(host), 24)], os.Getpid(), rand.Text()[:6]) // appProperties key and value limited to 124 bytes
+
 	err, ctx := error(nil), context.Background()
 	if config.AccessToken == "" || config.RefreshToken == "" {
 		if auth := config.UserAuth; auth != nil {
@@ -156,10 +277,47 @@ func NewGoogleDrive(config GoogleOauthConfig) (FS, error) {
 		}
 	}
 
-	if gdrive.driveService, err = drive.NewService(ctx, option.WithHTTPClient(gdrive.GoogleConfig.Client(ctx, gdrive.GoogleToken))); err != nil {
+	if config.SessionDB != "" {
+		if gdrive.sessions, err = cache.OpenSqlite[*UploadSession](config.SessionDB, "upload_sessions"); err != nil {
+			return nil, fmt.Errorf("cannot open sessions database: %v", err)
+		}
+	}
+
+	// Journal, sessions and deferred creates share one connection pool to journal.db
+	var journalData *sql.DB
+	if config.WriteBack != "" {
+		if err = os.MkdirAll(config.WriteBack, 0700); err != nil {
+			return nil, fmt.Errorf("cannot create write-back directory: %v", err)
+		} else if journalData, err = cache.OpenSqliteDB(filepath.Join(config.WriteBack, journalDB)); err != nil {
+			return nil, fmt.Errorf("cannot open write-back journal: %v", err)
+		}
+		if config.SessionDB == "" {
+			if gdrive.sessions, err = cache.NewSqlite[*UploadSession](journalData, "upload_sessions"); err != nil {
+				return nil, fmt.Errorf("cannot open sessions database: %v", err)
+			}
+		}
+	}
+
+	gdrive.client = gdrive.GoogleConfig.Client(ctx, gdrive.GoogleToken)
+	if gdrive.driveService, err = drive.NewService(ctx, option.WithHTTPClient(gdrive.client)); err != nil {
 		return nil, err
 	}
 
+	if config.BlockCache != "" {
+		if config.BlockSize <= 0 {
+			config.BlockSize = DefaultBlockSize
+		}
+		if gdrive.blocks, err = cache.OpenBlocks(config.BlockCache, config.BlockSize, config.BlockCacheSize); err != nil {
+			return nil, fmt.Errorf("cannot open block cache: %v", err)
+		}
+	}
+
+	if config.DeferCreate {
+		if err = gdrive.openCreates(journalData); err != nil {
+			return nil, fmt.Errorf("cannot open pending creates: %v", err)
+		}
+	}
+
 	if config.RootFolder != "" {
 		n := strings.Split(config.RootFolder, "/")
 		// Create folder with root id
@@ -182,6 +340,12 @@ func NewGoogleDrive(config GoogleOauthConfig) (FS, error) {
 		return nil, fmt.Errorf("cannot get root: %v", err)
 	}
 
+	if config.WriteBack != "" {
+		if err = gdrive.openJournal(config.WriteBack, journalData); err != nil {
+			return nil, fmt.Errorf("cannot open write-back journal: %v", err)
+		}
+	}
+
 	return gdrive, nil
 }
 
@@ -233,7 +397,19 @@ func openFileAPI(node *drive.FilesGetCall) (*http.Response, error) {
 }
 
 // List all files in folder
-func (gdrive *Gdrive) filesFromNode(folderID string) ([]*drive.File, error) {
+func (gdrive *Gdrive) filesFromNode(folderID string) (nodes []*drive.File, err error) {
+	// Append creates not sent to Google Drive
+	defer func() {
+		for _, node := range gdrive.creates.children(folderID) {
+			if err == nil && !slices.ContainsFunc(nodes, func(n *drive.File) bool { return n.Id == node.Id }) {
+				nodes = append(nodes, node)
+			}
+		}
+	}()
+	if gdrive.creates.pending(folderID) {
+		return []*drive.File{}, nil // Folder not created in Google Drive
+	}
+
 	if gdrive.cacheDir != nil {
 		nodes, err := gdrive.cacheDir.Get(folderID)
 		if err != nil && err != cache.ErrNotExist || len(nodes) > 0 {
@@ -241,7 +417,8 @@ func (gdrive *Gdrive) filesFromNode(folderID string) ([]*drive.File, error) {
 		}
 	}
 
-	folder, nodes := gdrive.driveService.Files.List().Fields("*").Q(fmt.Sprintf(GoogleListQuery, folderID)).PageSize(1000), []*drive.File{}
+	folder := gdrive.driveService.Files.List().Fields("*").Q(fmt.Sprintf(GoogleListQuery, folderID)).PageSize(1000)
+	nodes = []*drive.File{}
 	for {
 		res, err := folder.Do()
 		if err != nil {
@@ -249,7 +426,9 @@ func (gdrive *Gdrive) filesFromNode(folderID string) ([]*drive.File, error) {
 		}
 
 		for nodeIndex := range res.Files {
-			if !slices.Contains(DriveMimes, res.Files[nodeIndex].MimeType) {
+			if gdrive.isWorkspace(res.Files[nodeIndex]) {
+				nodes = append(nodes, gdrive.workspaceNode(res.Files[nodeIndex]))
+			} else if !slices.Contains(DriveMimes, res.Files[nodeIndex].MimeType) && !isTempNode(res.Files[nodeIndex]) {
 				nodes = append(nodes, res.Files[nodeIndex])
 			}
 		}
@@ -266,58 +445,37 @@ func (gdrive *Gdrive) filesFromNode(folderID string) ([]*drive.File, error) {
 	return nodes, nil
 }
 
-// Resolve node path from last node to fist/root path
+// Resolve node path from root, node outside root return "gdrive:<ID>"
 func (gdrive *Gdrive) forwardPathResolve(nodeID string) (string, error) {
-	pathNodes, fistNode, currentNode, err := []string{}, (*drive.File)(nil), (*drive.File)(nil), error(nil)
-	for {
-		if currentNode, err = gdrive.driveService.Files.Get(nodeID).Fields("*").Do(); err != nil {
-			break
-		}
-
-		// Loop to check if is shortcut
-		for limit := 200_000; limit > 0 && currentNode.MimeType == GoogleDriveMimeSyslink; limit-- {
-			if currentNode, err = gdrive.driveService.Files.Get(currentNode.ShortcutDetails.TargetId).Fields("*").Do(); err != nil {
-				break
-			}
+	pathNodes, target := []string{}, (*drive.File)(nil)
+	for currentID := nodeID; currentID != gdrive.rootDrive.Id; {
+		currentNode, err := gdrive.driveService.Files.Get(currentID).Fields("*").Do()
+		if err != nil {
+			return "", ProcessErr(fileRes(currentNode), err)
+		} else if len(currentNode.Parents) == 0 {
+			return "gdrive:" + nodeID, nil // Reached drive root, node is outside root
 		}
 
-		parents := len(currentNode.Parents)
-		if parents == 0 {
-			break // Stop count
-		} else if parents > 1 {
-			parentsNode, node := []*drive.File{}, (*drive.File)(nil)
-			for _, parentID := range currentNode.Parents {
-				if node, err = gdrive.driveService.Files.Get(parentID).Fields("*").Do(); err != nil {
-					break
-				}
-				parentsNode = append(parentsNode, node)
+		if target == nil {
+			if target = currentNode; gdrive.isWorkspace(target) {
+				target = gdrive.workspaceNode(target)
 			}
-			slices.SortFunc(parentsNode, func(i, j *drive.File) int {
-				ia, _ := time.Parse(time.RFC3339, i.CreatedTime)
-				ja, _ := time.Parse(time.RFC3339, j.CreatedTime)
-				return ia.Compare(ja)
-			})
-			currentNode = parentsNode[0]
-		}
-
-		if currentNode.Parents[0] == gdrive.rootDrive.Id {
-			break // Break loop
-		}
-		nodeID = currentNode.Parents[0]                 // set new nodeID
-		pathNodes = append(pathNodes, currentNode.Name) // Append name to path
-		if fistNode == nil {
-			fistNode = currentNode
+			currentNode = target
 		}
+		pathNodes = append(pathNodes, currentNode.Name)
+		currentID = currentNode.Parents[0] // Google Drive files have one parent
 	}
 
+	if len(pathNodes) == 0 {
+		return ".", nil // Root
+	}
 	slices.Reverse(pathNodes)
 	nodePath := path.Join(pathNodes...)
 	// Save path to cache
-	if err == nil && gdrive.cache != nil {
-		gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, nodePath), fistNode)
+	if target != nil && gdrive.cache != nil {
+		gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, nodePath), target)
 	}
-
-	return nodePath, ProcessErr(nil, err)
+	return nodePath, nil
 }
 
 // Get *drive.File if exist
@@ -345,7 +503,16 @@ func (gdrive *Gdrive) getNode(name string) (current *drive.File, err error) {
 		}
 
 		// Check if ared exist in folder
-		if current, err = getNodeFromFolder(gdrive.driveService, previus.Id, name); err != nil {
+		if current = gdrive.creates.lookup(previus.Id, name); current != nil {
+			continue // Waiting create
+		} else if current, err = getNodeFromFolder(gdrive.driveService, previus.Id, name); err == nil && gdrive.isWorkspace(current) {
+			err = fs.ErrNotExist // Google Workspace file is listed only with extension
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			if current, err = gdrive.workspaceFromFolder(previus.Id, name); err != nil {
+				return nil, err // Not exist or Google Workspace file with extension
+			}
+		} else if err != nil {
 			return nil, err // return drive error
 		}
 
@@ -371,6 +538,8 @@ func (gdrive *Gdrive) crFolder(name string) (node *drive.File, err error) {
 		previus = node
 		if node, err = gdrive.cache.Get(path.Join(gdrive.SubDir, folder)); err == nil && node != nil {
 			continue
+		} else if node = gdrive.creates.lookup(previus.Id, name); node != nil {
+			continue
 		} else if node, err = getNodeFromFolder(gdrive.driveService, previus.Id, name); err == nil {
 			if gdrive.cache != nil {
 				gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, folder), node)
@@ -378,13 +547,18 @@ func (gdrive *Gdrive) crFolder(name string) (node *drive.File, err error) {
 			continue
 		}
 
-		node, err = gdrive.driveService.Files.Create(&drive.File{
+		folderNode := &drive.File{
 			Name:       name,
 			MimeType:   GoogleDriveMimeFolder,
 			Parents:    []string{previus.Id},
 			Properties: map[string]string{UnixModeProperties: strconv.Itoa(int(fs.ModeDir | 0666))},
-		}).Fields("*").Do()
-		if err != nil {
+		}
+		var deferred bool
+		if node, deferred = gdrive.deferCreate(folder, folderNode); deferred {
+			continue
+		}
+
+		if node, err = gdrive.driveService.Files.Create(folderNode).Fields("*").Do(); err != nil {
 			return nil, ProcessErr(nil, err)
 		} else if gdrive.cache != nil {
 			gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, folder), node)
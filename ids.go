@@ -0,0 +1,469 @@
+package drivefs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"sirherobrine23.com.br/Sirherobrine23/drivefs/cache"
+)
+
+const (
+	IDPoolSize       = 100              // IDs requested to files.generateIds at once
+	CreateBatchSize  = 100              // Creates sent in one batch request, Google Drive limit is 100
+	CreateBatchDelay = time.Second      // Wait to group creates before send to Google Drive
+	createRetry      = time.Second * 30 // Wait to send creates after network error
+	idPoolLow        = IDPoolSize / 4   // Refill pool in background below this
+	createsTime      = JournalTime      // Time to keep pool and creates in write-back database
+)
+
+// Create returned to caller with ID from pool, sent to Google Drive later
+type pendingCreate struct {
+	Path string      `json:"path"` // Path in cache with SubDir
+	Node *drive.File `json:"node"` // Node to create, with pre-generated ID
+}
+
+// Pre-generated IDs and creates not sent to Google Drive
+type createQueue struct {
+	locker   sync.Mutex
+	flushing sync.Mutex                    // One flush running
+	ids      []string                      // IDs from files.generateIds
+	nodes    []*pendingCreate              // Creates in order, parent before children
+	sending  []string                      // Node IDs being created
+	uploads  []string                      // Node IDs created by upload running, kept in nodes until upload commit
+	refill   bool                          // files.generateIds running
+	pool     cache.Cache[[]string]         // Persist ids with write-back
+	store    cache.Cache[[]*pendingCreate] // Persist creates with write-back
+	wake     chan struct{}                 // Wake create worker
+	stop     context.CancelFunc            // Stop create worker
+}
+
+// Inode number from file ID, stable for creates not sent to Google Drive
+func (node NodeStat) Ino() uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(node.File.Id))
+	return hash.Sum64()
+}
+
+// Start deferred creates, with db pool and pending creates survive restart
+func (gdrive *Gdrive) openCreates(db *sql.DB) (err error) {
+	queue := &createQueue{wake: make(chan struct{}, 1)}
+	if db != nil {
+		if queue.pool, err = cache.NewSqlite[[]string](db, "id_pool"); err != nil {
+			return err
+		} else if queue.store, err = cache.NewSqlite[[]*pendingCreate](db, "pending_creates"); err != nil {
+			return err
+		}
+		queue.ids, _ = queue.pool.Get("ids")
+		queue.nodes, _ = queue.store.Get("nodes")
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	gdrive.creates, queue.stop = queue, stop
+	gdrive.tracker.wait.Add(1)
+	go gdrive.createWorker(ctx)
+	queue.notify()
+	return nil
+}
+
+// Wake create worker
+func (queue *createQueue) notify() {
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Save pool and creates, locker must be held
+func (queue *createQueue) save() error {
+	if queue.pool == nil {
+		return nil
+	} else if err := queue.pool.Set(createsTime, "ids", queue.ids); err != nil {
+		return err
+	}
+	return queue.store.Set(createsTime, "nodes", queue.nodes)
+}
+
+// Return true if node create is waiting or being sent
+func (queue *createQueue) pending(ids ...string) bool {
+	if queue == nil {
+		return false
+	}
+	queue.locker.Lock()
+	defer queue.locker.Unlock()
+	for _, id := range ids {
+		if id != "" && (slices.Contains(queue.sending, id) || slices.ContainsFunc(queue.nodes, func(create *pendingCreate) bool { return create.Node.Id == id })) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return node waiting create in folder
+func (queue *createQueue) lookup(folderID, name string) *drive.File {
+	for _, node := range queue.children(folderID) {
+		if node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// Return copy of nodes waiting create in folder
+func (queue *createQueue) children(folderID string) (nodes []*drive.File) {
+	if queue == nil {
+		return nil
+	}
+	queue.locker.Lock()
+	defer queue.locker.Unlock()
+	for _, create := range queue.nodes {
+		if slices.Contains(create.Node.Parents, folderID) {
+			node := *create.Node
+			nodes = append(nodes, &node)
+		}
+	}
+	return
+}
+
+// Mark create to be done by upload, false if not waiting create or already sent.
+//
+// Node stay in queue until upload finish with finish
+func (queue *createQueue) claim(id string) bool {
+	if queue == nil {
+		return false
+	}
+	queue.locker.Lock()
+	defer queue.locker.Unlock()
+	if !slices.ContainsFunc(queue.nodes, func(create *pendingCreate) bool { return create.Node.Id == id }) || slices.Contains(queue.sending, id) || slices.Contains(queue.uploads, id) {
+		return false
+	}
+	queue.uploads = append(queue.uploads, id)
+	return true
+}
+
+// Remove create from queue if created in Google Drive, else release claim to create worker send it
+func (queue *createQueue) finish(id string, created bool) (err error) {
+	if queue == nil {
+		return nil
+	}
+	queue.locker.Lock()
+	queue.uploads = slices.DeleteFunc(queue.uploads, func(upload string) bool { return upload == id })
+	if created {
+		queue.nodes = slices.DeleteFunc(queue.nodes, func(create *pendingCreate) bool { return create.Node.Id == id })
+		err = queue.save()
+	}
+	queue.locker.Unlock()
+	if !created {
+		queue.notify()
+	}
+	return err
+}
+
+// Remove create and creates inside it from queue, false if not waiting create
+func (queue *createQueue) drop(id string) (bool, error) {
+	if queue == nil {
+		return false, nil
+	}
+	queue.locker.Lock()
+	defer queue.locker.Unlock()
+	if slices.Contains(queue.sending, id) {
+		return false, nil
+	}
+
+	removed, found := []string{id}, false
+	queue.nodes = slices.DeleteFunc(queue.nodes, func(create *pendingCreate) bool {
+		for _, parent := range create.Node.Parents {
+			if slices.Contains(removed, parent) {
+				removed = append(removed, create.Node.Id)
+				return true
+			}
+		}
+		if create.Node.Id == id {
+			found = true
+			return true
+		}
+		return false
+	})
+	return found, queue.save()
+}
+
+// Get ID from pool, request more to Google Drive if pool is low
+func (gdrive *Gdrive) newID() (string, error) {
+	queue := gdrive.creates
+	queue.locker.Lock()
+	defer queue.locker.Unlock()
+	if len(queue.ids) == 0 {
+		queue.locker.Unlock()
+		err := gdrive.refillIDs()
+		queue.locker.Lock()
+		if err != nil && len(queue.ids) == 0 {
+			return "", err
+		}
+	} else if len(queue.ids) <= idPoolLow && !queue.refill {
+		queue.refill = true
+		go gdrive.refillIDs()
+	}
+
+	id := queue.ids[0]
+	queue.ids = queue.ids[1:]
+	return id, queue.save()
+}
+
+// Request IDs to files.generateIds
+func (gdrive *Gdrive) refillIDs() error {
+	res, err := gdrive.driveService.Files.GenerateIds().Count(IDPoolSize).Space("drive").Type("files").Do()
+
+	queue := gdrive.creates
+	queue.locker.Lock()
+	defer queue.locker.Unlock()
+	if queue.refill = false; err != nil {
+		return ProcessErr(nil, err)
+	}
+	queue.ids = append(queue.ids, res.Ids...)
+	return queue.save()
+}
+
+// Return node with pre-generated ID and send create to Google Drive later.
+//
+// Return false if deferred creates disabled or pool empty, caller must create node
+func (gdrive *Gdrive) deferCreate(name string, node *drive.File) (*drive.File, bool) {
+	if gdrive.creates == nil {
+		return nil, false
+	}
+
+	id, err := gdrive.newID()
+	if err != nil {
+		return nil, false
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	node.Id, node.CreatedTime, node.ModifiedTime = id, now, now
+	create := &pendingCreate{Path: path.Join(gdrive.SubDir, name), Node: node}
+
+	gdrive.creates.locker.Lock()
+	gdrive.creates.nodes = append(gdrive.creates.nodes, create)
+	if err = gdrive.creates.save(); err != nil {
+		gdrive.creates.nodes = gdrive.creates.nodes[:len(gdrive.creates.nodes)-1] // Caller create node now
+		gdrive.creates.locker.Unlock()
+		return nil, false
+	}
+	gdrive.creates.locker.Unlock()
+	gdrive.creates.notify()
+
+	copyNode := *node
+	if gdrive.cache != nil {
+		gdrive.cache.Set(DefaultCacheTime, create.Path, &copyNode)
+	}
+	if gdrive.cacheDir != nil {
+		gdrive.cacheDir.Delete(path.Join(gdrive.SubDir, path.Dir(name)))
+		for _, parent := range node.Parents {
+			gdrive.cacheDir.Delete(parent)
+		}
+	}
+	return &copyNode, true
+}
+
+// Return creates with parents already in Google Drive, locker must be held
+func (queue *createQueue) ready() (creates []*pendingCreate) {
+	for _, create := range queue.nodes {
+		waitParent := slices.ContainsFunc(create.Node.Parents, func(parent string) bool {
+			return slices.ContainsFunc(queue.nodes, func(other *pendingCreate) bool { return other.Node.Id == parent })
+		})
+		if !waitParent && !slices.Contains(queue.uploads, create.Node.Id) {
+			if creates = append(creates, create); len(creates) == CreateBatchSize {
+				break
+			}
+		}
+	}
+	return
+}
+
+// Send creates waiting in queue in batches, children are sent after parents
+func (gdrive *Gdrive) flushCreates() error {
+	queue := gdrive.creates
+	if queue == nil {
+		return nil
+	}
+	queue.flushing.Lock()
+	defer queue.flushing.Unlock()
+
+	errs := []error{}
+	for {
+		queue.locker.Lock()
+		creates := queue.ready()
+		if len(creates) == 0 {
+			queue.locker.Unlock()
+			return errors.Join(errs...)
+		}
+		queue.sending = nil
+		for _, create := range creates {
+			queue.sending = append(queue.sending, create.Node.Id)
+		}
+		queue.locker.Unlock()
+
+		nodes, createErrs, err := gdrive.batchCreate(creates)
+		queue.locker.Lock()
+		queue.sending = nil
+		queue.locker.Unlock()
+		if err != nil {
+			return errors.Join(append(errs, ProcessErr(nil, err))...) // Keep creates to next flush
+		}
+
+		retry := false
+		for index, create := range creates {
+			node, err := nodes[index], createErrs[index]
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+				node, err = gdrive.driveService.Files.Get(create.Node.Id).Fields("*").Do() // Created in previous flush
+			}
+
+			if err != nil && retryUpload(err) {
+				retry = true // Keep create to next flush
+				errs = append(errs, &fs.PathError{Op: "create", Path: create.Path, Err: ProcessErr(fileRes(node), err)})
+				continue
+			} else if err != nil {
+				_, dropErr := queue.drop(create.Node.Id) // Google Drive refused create, not try again
+				if gdrive.cache != nil {
+					gdrive.cache.Delete(create.Path)
+				}
+				errs = append(errs, &fs.PathError{Op: "create", Path: create.Path, Err: ProcessErr(fileRes(node), err)}, dropErr)
+				continue
+			} else if err = queue.finish(create.Node.Id, true); err != nil {
+				errs = append(errs, err)
+			}
+			if gdrive.cache != nil {
+				gdrive.cache.Set(DefaultCacheTime, create.Path, node)
+			}
+		}
+		if retry {
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// Send creates in one batch request, return node or error of each create.
+//
+// Error is returned only if batch request fail
+func (gdrive *Gdrive) batchCreate(creates []*pendingCreate) ([]*drive.File, []error, error) {
+	base, err := url.Parse(gdrive.driveService.BasePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for index, create := range creates {
+		metadata, err := json.Marshal(create.Node)
+		if err != nil {
+			return nil, nil, err
+		}
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}, "Content-Id": {fmt.Sprintf("<create-%d>", index)}})
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Fprintf(part, "POST %s?fields=* HTTP/1.1\r\nContent-Type: application/json; charset=UTF-8\r\nContent-Length: %d\r\n\r\n%s", path.Join("/", base.Path, "files"), len(metadata), metadata)
+	}
+	writer.Close()
+
+	batchURL := *base
+	batchURL.Path = path.Join("/batch", base.Path)
+	req, err := http.NewRequest(http.MethodPost, batchURL.String(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+
+	res, err := gdrive.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if err = googleapi.CheckResponse(res); err != nil {
+		return nil, nil, err
+	}
+	_, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodes, errs := make([]*drive.File, len(creates)), make([]error, len(creates))
+	for index := range errs {
+		errs[index] = errors.New("create not returned in batch response") // Retry in next flush
+	}
+	reader := multipart.NewReader(res.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		var index int
+		if _, err = fmt.Sscanf(part.Header.Get("Content-Id"), "<response-create-%d>", &index); err != nil || index < 0 || index >= len(creates) {
+			continue
+		}
+		createRes, err := http.ReadResponse(bufio.NewReader(part), req)
+		if err != nil {
+			errs[index] = err
+			continue
+		}
+		if errs[index] = googleapi.CheckResponse(createRes); errs[index] == nil {
+			nodes[index] = &drive.File{}
+			errs[index] = json.NewDecoder(createRes.Body).Decode(nodes[index])
+		}
+		createRes.Body.Close()
+	}
+	return nodes, errs, nil
+}
+
+// Send creates if any ids waiting create, to call Google Drive with ids
+func (gdrive *Gdrive) ensureCreated(ids ...string) error {
+	if gdrive.creates.pending(ids...) {
+		return gdrive.flushCreates()
+	}
+	return nil
+}
+
+// Send creates in batches until ctx done
+func (gdrive *Gdrive) createWorker(ctx context.Context) {
+	defer gdrive.tracker.wait.Done()
+	retry := (<-chan time.Time)(nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gdrive.creates.wake:
+		case <-retry:
+		}
+
+		// Wait more creates to send together
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(CreateBatchDelay):
+		}
+
+		if retry = nil; gdrive.flushCreates() != nil {
+			retry = time.After(createRetry)
+		}
+	}
+}
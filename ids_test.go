@@ -0,0 +1,111 @@
+package drivefs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestFlushCreatesBatch(t *testing.T) {
+	batches := [][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/batch" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		reader, writer := multipart.NewReader(r.Body, params["boundary"]), multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+
+		names := []string{}
+		for part, err := reader.NextPart(); err == nil; part, err = reader.NextPart() {
+			req, err := http.ReadRequest(bufio.NewReader(part))
+			if err != nil {
+				t.Errorf("invalid batch part: %s", err)
+				continue
+			}
+			var node drive.File
+			json.NewDecoder(req.Body).Decode(&node)
+			names = append(names, node.Name)
+
+			response, _ := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}, "Content-Id": {"<response-" + part.Header.Get("Content-Id")[1:]}})
+			data, _ := json.Marshal(&node)
+			fmt.Fprintf(response, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(data), data)
+		}
+		writer.Close()
+		batches = append(batches, names)
+	}))
+	defer server.Close()
+
+	service, client := testService(t, server)
+	gdrive := &Gdrive{client: client, driveService: service, creates: &createQueue{wake: make(chan struct{}, 1)}}
+	gdrive.creates.nodes = []*pendingCreate{
+		{Path: "folder", Node: &drive.File{Id: "folder", Name: "folder", Parents: []string{"root"}}},
+		{Path: "folder/a", Node: &drive.File{Id: "a", Name: "a", Parents: []string{"folder"}}},
+		{Path: "b", Node: &drive.File{Id: "b", Name: "b", Parents: []string{"root"}}},
+		{Path: "folder/c", Node: &drive.File{Id: "c", Name: "c", Parents: []string{"folder"}}},
+	}
+
+	if err := gdrive.flushCreates(); err != nil {
+		t.Fatal(err)
+	} else if fmt.Sprint(batches) != "[[folder b] [a c]]" {
+		t.Errorf("batches %v, expected [[folder b] [a c]]", batches)
+	} else if gdrive.creates.pending("folder", "a", "b", "c") {
+		t.Errorf("creates still pending after flush")
+	}
+}
+
+func TestDeferCreateUpload(t *testing.T) {
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && fail:
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":{"code":400,"message":"invalid"}}`)
+		case r.Method == http.MethodPost:
+			w.Header().Set("Location", "http://"+r.Host+"/session")
+		case r.Method == http.MethodPut:
+			io.Copy(io.Discard, r.Body)
+			io.WriteString(w, `{"id":"file","name":"file.txt"}`)
+		}
+	}))
+	defer server.Close()
+
+	service, client := testService(t, server)
+	gdrive := &Gdrive{client: client, driveService: service, quota: &quotaState{}, creates: &createQueue{wake: make(chan struct{}, 1)}}
+	node := &drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Parents: []string{"root"}}
+	gdrive.creates.nodes = []*pendingCreate{{Path: "file.txt", Node: node}}
+
+	// Placeholder kept while upload run and after upload fail
+	session := gdrive.newSession(node, nil)
+	if session.FileID != "" || session.Metadata.Id != "file" {
+		t.Fatalf("create not claimed by session: %+v", session)
+	} else if len(gdrive.creates.ready()) != 0 {
+		t.Errorf("create claimed by upload sent by worker")
+	} else if gdrive.creates.lookup("root", "file.txt") == nil {
+		t.Errorf("file not visible while upload run")
+	}
+	if _, err := gdrive.upload(session, strings.NewReader("Google drive")); err == nil {
+		t.Fatal("upload error not returned")
+	} else if len(gdrive.creates.ready()) != 1 {
+		t.Errorf("create not returned to worker after upload fail")
+	}
+
+	fail = false
+	session = gdrive.newSession(node, nil)
+	if _, err := gdrive.upload(session, strings.NewReader("Google drive")); err != nil {
+		t.Fatal(err)
+	} else if gdrive.creates.pending("file") {
+		t.Errorf("create still pending after upload commit")
+	}
+}
@@ -0,0 +1,375 @@
+package drivefs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"sirherobrine23.com.br/Sirherobrine23/drivefs/cache"
+)
+
+// Upload state in write-back journal
+type JournalState string
+
+const (
+	JournalOpen    JournalState = "open"    // Write handle still open, content is local only
+	JournalPending JournalState = "pending" // Waiting upload
+	JournalFailed  JournalState = "failed"  // Upload failed JournalRetries times, use RetryUpload
+
+	JournalRetries  = 5                    // Upload attempts before mark failed
+	JournalTime     = time.Hour * 24 * 365 // Time to keep entry in journal database
+	JournalInterval = time.Second * 30     // Interval to check pending uploads
+	journalBackoff  = time.Second * 15     // First retry wait, doubled on each attempt
+	journalDB       = "journal.db"         // Journal sqlite file in write-back directory
+)
+
+// Write saved locally and waiting upload
+type JournalEntry struct {
+	ID        string         `json:"id"`                   // Entry ID
+	Path      string         `json:"path"`                 // File path in Gdrive with SubDir
+	LocalFile string         `json:"local_file"`           // Local file with content to upload
+	Node      *drive.File    `json:"node"`                 // Node seen on open, without ID to create file
+	Options   *CreateOptions `json:"options,omitempty"`    // Upload options
+	State     JournalState   `json:"state"`                // Upload state
+	Attempts  int            `json:"attempts"`             // Upload attempts
+	LastError string         `json:"last_error,omitempty"` // Last upload error
+	Created   time.Time      `json:"created"`              // Write open time
+	Updated   time.Time      `json:"updated"`              // Last state change
+	NextTry   time.Time      `json:"next_try,omitzero"`    // Wait to upload after error
+}
+
+// Write-back journal and upload worker
+type journal struct {
+	dir      string
+	entries  cache.Cache[*JournalEntry]
+	metadata cache.Cache[*pendingMetadata] // Metadata to apply after upload, key is path
+	locker   sync.Mutex                    // Change of metadata
+	paths    map[string][]*JournalEntry    // Entries by path sorted by creation
+	saving   sync.Mutex                    // Change and save of indexed entries
+	pathLock sync.RWMutex
+	wake     chan struct{}
+	stop     context.CancelFunc
+}
+
+// Open journal in dir with tables in db and start upload worker
+func (gdrive *Gdrive) openJournal(dir string, db *sql.DB) (err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	gdrive.journal = &journal{dir: dir, wake: make(chan struct{}, 1), stop: stop}
+	if gdrive.journal.entries, err = cache.NewSqlite[*JournalEntry](db, "journal"); err != nil {
+		return err
+	} else if gdrive.journal.metadata, err = cache.NewSqlite[*pendingMetadata](db, "journal_metadata"); err != nil {
+		return err
+	}
+
+	// Handles open in previous process not be closed
+	entries, err := gdrive.journal.list()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		gdrive.journal.index(entry)
+		if entry.State == JournalOpen {
+			entry.State = JournalPending
+			if err = gdrive.journal.save(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	gdrive.tracker.wait.Add(1)
+	go gdrive.journalWorker(ctx)
+	return nil
+}
+
+// List entries sorted by creation
+func (journal *journal) list() ([]*JournalEntry, error) {
+	values, err := journal.entries.Values()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*JournalEntry{}
+	for _, entry := range values {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b *JournalEntry) int { return a.Created.Compare(b.Created) })
+	return entries, nil
+}
+
+// Return last entry to path
+func (journal *journal) find(name string) *JournalEntry {
+	journal.pathLock.RLock()
+	defer journal.pathLock.RUnlock()
+	if entries := journal.paths[name]; len(entries) > 0 {
+		return entries[len(entries)-1]
+	}
+	return nil
+}
+
+// Add or replace entry in path index
+func (journal *journal) index(entry *JournalEntry) {
+	journal.pathLock.Lock()
+	defer journal.pathLock.Unlock()
+	if journal.paths == nil {
+		journal.paths = map[string][]*JournalEntry{}
+	}
+	entries := slices.DeleteFunc(journal.paths[entry.Path], func(old *JournalEntry) bool { return old.ID == entry.ID })
+	entries = append(entries, entry)
+	slices.SortStableFunc(entries, func(a, b *JournalEntry) int { return a.Created.Compare(b.Created) })
+	journal.paths[entry.Path] = entries
+}
+
+func (journal *journal) save(entry *JournalEntry) error {
+	journal.saving.Lock()
+	defer journal.saving.Unlock()
+	entry.Updated = time.Now()
+	if err := journal.entries.Set(JournalTime, entry.ID, entry); err != nil {
+		return err
+	}
+	journal.index(entry)
+	return nil
+}
+
+// Set node uploaded by entry as base of entries opened later to same path, so their upload not conflict with it
+func (journal *journal) rebase(entry *JournalEntry, node *drive.File) error {
+	journal.pathLock.RLock()
+	later := slices.DeleteFunc(slices.Clone(journal.paths[entry.Path]), func(other *JournalEntry) bool { return !other.Created.After(entry.Created) })
+	journal.pathLock.RUnlock()
+
+	errs := []error{}
+	for _, other := range later {
+		base := *node
+		journal.saving.Lock()
+		other.Node = &base
+		journal.saving.Unlock()
+		errs = append(errs, journal.save(other))
+	}
+	return errors.Join(errs...)
+}
+
+func (journal *journal) remove(entry *JournalEntry) error {
+	journal.pathLock.Lock()
+	entries := slices.DeleteFunc(journal.paths[entry.Path], func(old *JournalEntry) bool { return old.ID == entry.ID })
+	if len(entries) == 0 {
+		delete(journal.paths, entry.Path)
+	} else {
+		journal.paths[entry.Path] = entries
+	}
+	journal.pathLock.Unlock()
+	return errors.Join(journal.entries.Delete(entry.ID), os.Remove(entry.LocalFile))
+}
+
+// Wake upload worker
+func (journal *journal) notify() {
+	select {
+	case journal.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Create local file in journal directory and entry in open state
+func (journal *journal) create(name string, node *drive.File, options *CreateOptions) (*JournalEntry, *os.File, error) {
+	entry := &JournalEntry{ID: rand.Text(), Path: name, Node: node, Options: options, State: JournalOpen, Created: time.Now()}
+	entry.LocalFile = filepath.Join(journal.dir, entry.ID)
+
+	file, err := os.OpenFile(entry.LocalFile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, nil, err
+	} else if err = journal.save(entry); err != nil {
+		file.Close()
+		os.Remove(entry.LocalFile)
+		return nil, nil, err
+	}
+	return entry, file, nil
+}
+
+// Upload pending entries until ctx done
+func (gdrive *Gdrive) journalWorker(ctx context.Context) {
+	defer gdrive.tracker.wait.Done()
+	for {
+		entries, _ := gdrive.journal.list()
+		for _, entry := range entries {
+			if ctx.Err() != nil || gdrive.uploadPaused() != nil {
+				break
+			} else if entry.State == JournalPending && !entry.NextTry.After(time.Now()) {
+				if entry, _ = gdrive.journal.entries.Get(entry.ID); entry == nil {
+					continue // Removed after list
+				} else if err := gdrive.uploadEntry(entry); err != nil {
+					log.Printf("drivefs: cannot save upload %s of %s: %s", entry.ID, entry.Path, err)
+				}
+			}
+		}
+		if ctx.Err() == nil {
+			if err := gdrive.flushMetadata(); err != nil {
+				log.Printf("drivefs: cannot save pending metadata: %s", err)
+			}
+		}
+
+		// Wait quota reset to continue uploads
+		wait := JournalInterval
+		if quota := gdrive.UploadQuota(); quota != nil {
+			wait = time.Until(quota.Resume)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-gdrive.journal.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Upload entry content, continue session started by previous attempt.
+//
+// Upload errors are saved in entry, return error only if journal cannot be updated
+func (gdrive *Gdrive) uploadEntry(entry *JournalEntry) error {
+	file, err := os.Open(entry.LocalFile)
+	if err != nil {
+		entry.State, entry.LastError = JournalFailed, err.Error()
+		return gdrive.journal.save(entry)
+	}
+	defer file.Close()
+
+	var session *UploadSession
+	if gdrive.sessions != nil {
+		session, _ = gdrive.sessions.Get(entry.ID)
+	}
+	if session != nil && session.FileID == "" && session.Metadata.Id != "" && !gdrive.creates.claim(session.Metadata.Id) {
+		gdrive.sessions.Delete(entry.ID)
+		session = nil // Deferred create sent after session start, new session upload to it
+	}
+
+	resume := session != nil
+	if !resume {
+		session = gdrive.newSession(entry.Node, entry.Options)
+	}
+	session.queued, session.entry = true, entry.ID // Worker retry after quota reset
+
+	var node *drive.File
+	if resume {
+		node, err = gdrive.resumeSession(session, file)
+	} else {
+		node, err = gdrive.upload(session, file)
+	}
+
+	var quota *QuotaError
+	if errors.As(err, &quota) {
+		entry.LastError = err.Error() // Retry after quota reset without count attempt
+		return gdrive.journal.save(entry)
+	} else if err != nil {
+		entry.Attempts++
+		entry.LastError = err.Error()
+		entry.NextTry = time.Now().Add(journalBackoff << min(entry.Attempts, 10))
+		if entry.Attempts >= JournalRetries || errors.Is(err, ErrConflict) || errors.Is(err, ErrChecksum) {
+			entry.State = JournalFailed
+		}
+		return gdrive.journal.save(entry)
+	}
+
+	node = gdrive.cacheUploaded(entry.Path, node)
+	return errors.Join(gdrive.journal.rebase(entry, node), gdrive.journal.remove(entry))
+}
+
+// Return last journal entry to name, nil if write-back disabled or no upload waiting
+func (gdrive *Gdrive) journalEntry(name string) *JournalEntry {
+	if gdrive.journal == nil {
+		return nil
+	}
+	return gdrive.journal.find(path.Join(gdrive.SubDir, name))
+}
+
+// Return node with size and modified time of content waiting upload, node is nil for files not created yet
+func (gdrive *Gdrive) journalNode(name string, node *drive.File) *drive.File {
+	entry := gdrive.journalEntry(name)
+	if entry == nil {
+		return node
+	}
+	info, err := os.Stat(entry.LocalFile)
+	if err != nil {
+		return node
+	}
+
+	var pending drive.File
+	if node != nil {
+		pending = *node
+	} else {
+		gdrive.journal.saving.Lock()
+		pending = *entry.Node
+		gdrive.journal.saving.Unlock()
+	}
+	pending.Size, pending.ModifiedTime = info.Size(), info.ModTime().UTC().Format(time.RFC3339Nano)
+	pending.Md5Checksum, pending.Sha1Checksum, pending.Sha256Checksum = "", "", "" // Checksum of content in Google Drive
+	return &pending
+}
+
+// Return copy of files listed in folder name with journal entries applied, including files not created yet
+func (gdrive *Gdrive) journalChildren(name string, files []*drive.File) []*drive.File {
+	if gdrive.journal == nil {
+		return files
+	}
+
+	folder, names := path.Join("/", gdrive.SubDir, name), []string{}
+	gdrive.journal.pathLock.RLock()
+	for key := range gdrive.journal.paths {
+		if path.Join("/", path.Dir(key)) == folder {
+			names = append(names, path.Base(key))
+		}
+	}
+	gdrive.journal.pathLock.RUnlock()
+
+	files = slices.Clone(files)
+	for _, base := range names {
+		child := pathManipulate(path.Join(name, base)).CleanPath()
+		if index := slices.IndexFunc(files, func(file *drive.File) bool { return file.Name == base }); index != -1 {
+			files[index] = gdrive.journalNode(child, files[index])
+		} else if node := gdrive.journalNode(child, nil); node != nil {
+			files = append(files, node)
+		}
+	}
+	return files
+}
+
+// List uploads waiting or failed in write-back journal
+func (gdrive *Gdrive) PendingUploads() ([]*JournalEntry, error) {
+	if gdrive.journal == nil {
+		return nil, nil
+	}
+	return gdrive.journal.list()
+}
+
+// Move failed upload to pending and wake upload worker
+func (gdrive *Gdrive) RetryUpload(id string) error {
+	if gdrive.journal == nil {
+		return fs.ErrInvalid
+	}
+
+	entry, err := gdrive.journal.entries.Get(id)
+	if err != nil || entry == nil {
+		return fmt.Errorf("upload %s: %w", id, fs.ErrNotExist)
+	} else if entry.State == JournalOpen {
+		return fmt.Errorf("upload %s: %w", id, fs.ErrInvalid)
+	}
+
+	entry.State, entry.Attempts, entry.NextTry = JournalPending, 0, time.Time{}
+	if err = gdrive.journal.save(entry); err != nil {
+		return err
+	}
+	gdrive.journal.notify()
+	return nil
+}
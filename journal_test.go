@@ -0,0 +1,326 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"sirherobrine23.com.br/Sirherobrine23/drivefs/cache"
+)
+
+// Fake Google Drive with one file, each upload commit new revision
+type revisionServer struct {
+	locker  sync.Mutex
+	remote  drive.File
+	uploads []string // Content of each upload commit
+	created []string // Names of files created by upload
+	refuse  int      // Upload commits to refuse
+}
+
+func (server *revisionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.locker.Lock()
+	defer server.locker.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(&server.remote)
+	case http.MethodPatch, http.MethodPost:
+		if r.Method == http.MethodPost {
+			var node drive.File
+			json.NewDecoder(r.Body).Decode(&node)
+			server.created = append(server.created, node.Name)
+		}
+		w.Header().Set("Location", "http://"+r.Host+"/session")
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if server.refuse > 0 {
+			server.refuse--
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":{"code":400,"message":"invalid"}}`)
+			return
+		}
+		server.uploads = append(server.uploads, string(data))
+		server.remote.Version++
+		server.remote.HeadRevisionId = fmt.Sprintf("r%d", server.remote.Version)
+		server.remote.Size = int64(len(data))
+		json.NewEncoder(w).Encode(&server.remote)
+	}
+}
+
+// Open write-back journal in dir with worker uploading to server, stopped on test cleanup
+func journalDrive(t *testing.T, server *httptest.Server, dir string, policy ConflictPolicy) *Gdrive {
+	service, client := testService(t, server)
+	gdrive := &Gdrive{client: client, driveService: service, ChunkSize: UploadChunkAlign, ConflictPolicy: policy, quota: &quotaState{}, tracker: &uploadTracker{files: map[io.Closer]struct{}{}}}
+	gdrive.rootDrive = &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder}
+	db, err := cache.OpenSqliteDB(filepath.Join(dir, journalDB))
+	if err != nil {
+		t.Fatal(err)
+	} else if gdrive.sessions, err = cache.NewSqlite[*UploadSession](db, "upload_sessions"); err != nil {
+		t.Fatal(err)
+	} else if err = gdrive.openJournal(dir, db); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := sync.OnceFunc(func() {
+		gdrive.journal.stop()
+		gdrive.tracker.wait.Wait()
+		db.Close()
+	})
+	t.Cleanup(stop)
+	return gdrive
+}
+
+// Wait worker process entries ready to upload, return entries left in journal
+func waitJournal(t *testing.T, gdrive *Gdrive) []*JournalEntry {
+	for range 500 {
+		entries, err := gdrive.PendingUploads()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending := false
+		for _, entry := range entries {
+			pending = pending || entry.State == JournalPending && !entry.NextTry.After(time.Now())
+		}
+		if !pending {
+			return entries
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("journal worker not finished uploads")
+	return nil
+}
+
+// Write content to new journal entry left open, as process killed before Close
+func writeEntry(t *testing.T, gdrive *Gdrive, name string, node *drive.File, content string) *JournalEntry {
+	base := *node
+	entry, file, err := gdrive.journal.create(name, &base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = io.WriteString(file, content); err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestJournalReplayRebase(t *testing.T) {
+	files := &revisionServer{remote: drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Version: 1, HeadRevisionId: "r1"}}
+	server := httptest.NewServer(files)
+	defer server.Close()
+
+	// Two edits opened on same revision, both waiting upload on restart
+	dir := t.TempDir()
+	gdrive := journalDrive(t, server, dir, ConflictFail)
+	node := files.remote
+	writeEntry(t, gdrive, "file.txt", &node, "first")
+	writeEntry(t, gdrive, "file.txt", &node, "second")
+	gdrive.journal.stop()
+	gdrive.tracker.wait.Wait()
+
+	gdrive = journalDrive(t, server, dir, ConflictFail)
+	if entries := waitJournal(t, gdrive); len(entries) != 0 {
+		t.Fatalf("entries left in journal: %s", entries[0].LastError)
+	}
+
+	files.locker.Lock()
+	defer files.locker.Unlock()
+	if fmt.Sprint(files.uploads) != "[first second]" {
+		t.Errorf("uploads %q, expected first and second in order", files.uploads)
+	}
+}
+
+func TestJournalConflict(t *testing.T) {
+	files := &revisionServer{remote: drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Version: 1, HeadRevisionId: "r1"}}
+	server := httptest.NewServer(files)
+	defer server.Close()
+
+	dir := t.TempDir()
+	gdrive := journalDrive(t, server, dir, ConflictFail)
+	node := files.remote
+	entry := writeEntry(t, gdrive, "file.txt", &node, "local")
+	files.locker.Lock()
+	files.remote.Version, files.remote.HeadRevisionId = 2, "r2" // Changed by other client
+	files.locker.Unlock()
+	gdrive.journal.stop()
+	gdrive.tracker.wait.Wait()
+
+	// Failed without retry, content kept to RetryUpload
+	gdrive = journalDrive(t, server, dir, ConflictFail)
+	entries := waitJournal(t, gdrive)
+	if len(entries) != 1 || entries[0].State != JournalFailed || !strings.Contains(entries[0].LastError, ErrConflict.Error()) {
+		t.Fatalf("conflict not marked failed: %+v", entries)
+	} else if entries[0].Attempts != 1 {
+		t.Errorf("conflict retried %d times", entries[0].Attempts)
+	}
+	gdrive.journal.stop()
+	gdrive.tracker.wait.Wait()
+
+	// Retry with ConflictCopy save content to conflicted copy
+	gdrive = journalDrive(t, server, dir, ConflictCopy)
+	if err := gdrive.RetryUpload(entry.ID); err != nil {
+		t.Fatal(err)
+	} else if entries = waitJournal(t, gdrive); len(entries) != 0 {
+		t.Fatalf("entries left in journal: %+v", entries)
+	}
+
+	files.locker.Lock()
+	defer files.locker.Unlock()
+	if len(files.created) != 1 || !strings.HasPrefix(files.created[0], "file (conflicted copy ") {
+		t.Errorf("conflicted copy not created: %q", files.created)
+	} else if fmt.Sprint(files.uploads) != "[local]" {
+		t.Errorf("uploads %q", files.uploads)
+	}
+}
+
+func TestJournalFailedRetry(t *testing.T) {
+	files := &revisionServer{remote: drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Version: 1, HeadRevisionId: "r1"}, refuse: 1}
+	server := httptest.NewServer(files)
+	defer server.Close()
+
+	dir := t.TempDir()
+	gdrive := journalDrive(t, server, dir, ConflictFail)
+	node := files.remote
+	entry := writeEntry(t, gdrive, "file.txt", &node, "content")
+	gdrive.journal.stop()
+	gdrive.tracker.wait.Wait()
+
+	// Upload error wait backoff to retry
+	gdrive = journalDrive(t, server, dir, ConflictFail)
+	entries := waitJournal(t, gdrive)
+	if len(entries) != 1 || entries[0].State != JournalPending || entries[0].Attempts != 1 || entries[0].LastError == "" {
+		t.Fatalf("upload error not saved: %+v", entries)
+	} else if !entries[0].NextTry.After(time.Now()) {
+		t.Errorf("retry not delayed")
+	}
+
+	if err := gdrive.RetryUpload(entry.ID); err != nil {
+		t.Fatal(err)
+	} else if entries = waitJournal(t, gdrive); len(entries) != 0 {
+		t.Fatalf("entries left after retry: %+v", entries)
+	}
+
+	// Retries exhausted
+	files.locker.Lock()
+	files.refuse = JournalRetries
+	node = files.remote
+	files.locker.Unlock()
+	entry = writeEntry(t, gdrive, "file.txt", &node, "content")
+	entry.State, entry.Attempts = JournalPending, JournalRetries-1
+	if err := gdrive.journal.save(entry); err != nil {
+		t.Fatal(err)
+	}
+	gdrive.journal.notify()
+	if entries = waitJournal(t, gdrive); len(entries) != 1 || entries[0].State != JournalFailed {
+		t.Fatalf("entry not failed after %d attempts: %+v", JournalRetries, entries)
+	}
+}
+
+func TestJournalStat(t *testing.T) {
+	node := &drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Parents: []string{"root"}, Size: 4, Version: 1}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		files := []*drive.File{node}
+		if query := r.URL.Query().Get("q"); strings.Contains(query, "name =") && !strings.Contains(query, "'file.txt'") {
+			files = nil
+		}
+		json.NewEncoder(w).Encode(&drive.FileList{Files: files})
+	}))
+	defer server.Close()
+
+	gdrive := journalDrive(t, server, t.TempDir(), ConflictFail)
+	writeEntry(t, gdrive, "file.txt", node, "local content")
+	writeEntry(t, gdrive, "new.txt", &drive.File{Name: "new.txt", MimeType: "text/plain", Parents: []string{"root"}}, "new")
+
+	for name, size := range map[string]int64{"file.txt": 13, "new.txt": 3} {
+		if info, err := gdrive.Stat(name); err != nil {
+			t.Errorf("stat %s: %s", name, err)
+		} else if info.Size() != size {
+			t.Errorf("stat %s: size %d, expected %d", name, info.Size(), size)
+		} else if info, err = gdrive.Lstat(name); err != nil || info.Size() != size {
+			t.Errorf("lstat %s: size %d, %v", name, info.Size(), err)
+		}
+	}
+
+	entries, err := gdrive.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]int64{}
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		sizes[entry.Name()] = info.Size()
+	}
+	if fmt.Sprint(sizes) != "map[file.txt:13 new.txt:3]" {
+		t.Errorf("readdir %v", sizes)
+	}
+
+	if data, err := gdrive.ReadFile("new.txt"); err != nil || string(data) != "new" {
+		t.Errorf("read new file %q, %v", data, err)
+	}
+}
+
+func TestJournalResumeNewFile(t *testing.T) {
+	var locker sync.Mutex
+	posts, received, refuse := 0, 0, true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locker.Lock()
+		defer locker.Unlock()
+		switch {
+		case r.Method == http.MethodPost:
+			posts, received = posts+1, 0
+			w.Header().Set("Location", "http://"+r.Host+"/session")
+		case r.Method == http.MethodPut && strings.HasPrefix(r.Header.Get("Content-Range"), "bytes */"):
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", received-1))
+			w.WriteHeader(http.StatusPermanentRedirect)
+		case r.Method == http.MethodPut && strings.HasSuffix(r.Header.Get("Content-Range"), "/*"):
+			data, _ := io.ReadAll(r.Body)
+			received += len(data)
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", received-1))
+			w.WriteHeader(http.StatusPermanentRedirect)
+		case r.Method == http.MethodPut && refuse:
+			refuse = false
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":{"code":400,"message":"invalid"}}`)
+		case r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			received += len(data)
+			io.WriteString(w, `{"id":"new","name":"new.bin"}`)
+		}
+	}))
+	defer server.Close()
+
+	// First chunk confirmed before last chunk fail
+	dir := t.TempDir()
+	gdrive := journalDrive(t, server, dir, ConflictFail)
+	content := strings.Repeat("drivefs", int(UploadChunkAlign)/7+1)
+	entry := writeEntry(t, gdrive, "new.bin", &drive.File{Name: "new.bin", MimeType: "application/octet-stream", Parents: []string{"root"}}, content)
+	gdrive.journal.stop()
+	gdrive.tracker.wait.Wait()
+	gdrive = journalDrive(t, server, dir, ConflictFail)
+	if entries := waitJournal(t, gdrive); len(entries) != 1 || entries[0].Attempts != 1 {
+		t.Fatalf("upload error not saved: %+v", entries)
+	}
+	gdrive.journal.stop()
+	gdrive.tracker.wait.Wait()
+
+	// Continue session of new file after restart
+	gdrive = journalDrive(t, server, dir, ConflictFail)
+	if err := gdrive.RetryUpload(entry.ID); err != nil {
+		t.Fatal(err)
+	} else if entries := waitJournal(t, gdrive); len(entries) != 0 {
+		t.Fatalf("entries left after resume: %+v", entries)
+	}
+
+	locker.Lock()
+	defer locker.Unlock()
+	if posts != 1 || received != len(content) {
+		t.Errorf("%d sessions started and %d bytes received, expected 1 session and %d bytes", posts, received, len(content))
+	}
+}
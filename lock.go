@@ -0,0 +1,193 @@
+package drivefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	LockAppProperties string = "drivefsLock." // Prefix of lock holders in appProperties, value is "<x|s>:<expire unix>"
+	DefaultLockTTL           = time.Minute    // Lock lease time if ttl is zero
+)
+
+// Locks held by this client, renewed in background until Unlock
+type lockTable struct {
+	locker sync.Mutex
+	held   map[string]*heldLock // File ID to lease renew
+}
+
+// Lease renew of lock, ctx cause is renew error if lock lost
+type heldLock struct {
+	ctx  context.Context
+	stop context.CancelCauseFunc
+}
+
+// Lock holder in file appProperties
+type lockHolder struct {
+	owner     string
+	exclusive bool
+	expire    time.Time
+}
+
+// Parse lock holders from appProperties
+func lockHolders(appProperties map[string]string) (holders []lockHolder) {
+	for key, value := range appProperties {
+		owner, ok := strings.CutPrefix(key, LockAppProperties)
+		if !ok {
+			continue
+		}
+		mode, expire, _ := strings.Cut(value, ":")
+		unix, err := strconv.ParseInt(expire, 10, 64)
+		if err != nil {
+			continue
+		}
+		holders = append(holders, lockHolder{owner: owner, exclusive: mode == "x", expire: time.Unix(unix, 0)})
+	}
+	return
+}
+
+// Check if other live holder block lock
+func lockConflict(holders []lockHolder, owner string, exclusive bool) bool {
+	return slices.ContainsFunc(holders, func(holder lockHolder) bool {
+		return holder.owner != owner && holder.expire.After(time.Now()) && (exclusive || holder.exclusive)
+	})
+}
+
+// Write lock holder to file, removing expired holders from other clients
+func (gdrive *Gdrive) writeLock(fileID string, exclusive bool, ttl time.Duration) error {
+	if err := gdrive.ensureCreated(fileID); err != nil {
+		return err
+	}
+	node, err := gdrive.driveService.Files.Get(fileID).Fields("id,version,appProperties").Do()
+	if err != nil {
+		return ProcessErr(fileRes(node), err)
+	}
+
+	holders := lockHolders(node.AppProperties)
+	if lockConflict(holders, gdrive.lockOwner, exclusive) {
+		return ErrLocked
+	}
+
+	mode := "s"
+	if exclusive {
+		mode = "x"
+	}
+	update := &drive.File{AppProperties: map[string]string{
+		LockAppProperties + gdrive.lockOwner: fmt.Sprintf("%s:%d", mode, time.Now().Add(ttl).Unix()),
+	}}
+	for _, holder := range holders {
+		if holder.owner != gdrive.lockOwner && !holder.expire.After(time.Now()) {
+			update.NullFields = append(update.NullFields, "AppProperties."+LockAppProperties+holder.owner) // Stale lock takeover
+		}
+	}
+
+	if node, err = gdrive.driveService.Files.Update(fileID, update).Fields("id,version,appProperties").Do(); err != nil {
+		return ProcessErr(fileRes(node), err)
+	}
+
+	// Other client write lock in same time, release and let caller retry
+	if lockConflict(lockHolders(node.AppProperties), gdrive.lockOwner, exclusive) {
+		return errors.Join(ErrLocked, gdrive.removeLock(fileID))
+	}
+	return nil
+}
+
+// Remove lock holder of this client from file
+func (gdrive *Gdrive) removeLock(fileID string) error {
+	update := &drive.File{NullFields: []string{"AppProperties." + LockAppProperties + gdrive.lockOwner}}
+	node, err := gdrive.driveService.Files.Update(fileID, update).Fields("id").Do()
+	return ProcessErr(fileRes(node), err)
+}
+
+// Lock take advisory lock of file shared with others drivefs clients.
+//
+// Exclusive lock fail if file have any lock, shared lock fail only with exclusive lock,
+// locks expired from clients without renew are taken over.
+// Lock is renewed in background until Unlock or Close, if renew fail lock is lost and Unlock return error
+func (gdrive *Gdrive) Lock(name string, exclusive bool, ttl time.Duration) error {
+	name = pathManipulate(name).CleanPath()
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+
+	node, err := gdrive.getNode(name)
+	if err != nil {
+		return &fs.PathError{Op: "lock", Path: name, Err: ProcessErr(fileRes(node), err)}
+	} else if err = gdrive.writeLock(node.Id, exclusive, ttl); err != nil {
+		return &fs.PathError{Op: "lock", Path: name, Err: err}
+	}
+
+	ctx, stop := context.WithCancelCause(context.Background())
+	gdrive.locks.locker.Lock()
+	if held, ok := gdrive.locks.held[node.Id]; ok {
+		held.stop(nil) // Lock mode changed
+	}
+	gdrive.locks.held[node.Id] = &heldLock{ctx, stop}
+	gdrive.locks.locker.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := gdrive.writeLock(node.Id, exclusive, ttl); err != nil {
+					stop(fmt.Errorf("lock lost: %w", err)) // Not renew lock other client can take after lease
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Unlock release lock taken by Lock
+func (gdrive *Gdrive) Unlock(name string) error {
+	name = pathManipulate(name).CleanPath()
+	node, err := gdrive.getNode(name)
+	if err != nil {
+		return &fs.PathError{Op: "unlock", Path: name, Err: ProcessErr(fileRes(node), err)}
+	}
+
+	gdrive.locks.locker.Lock()
+	held, ok := gdrive.locks.held[node.Id]
+	delete(gdrive.locks.held, node.Id)
+	gdrive.locks.locker.Unlock()
+	if !ok {
+		return &fs.PathError{Op: "unlock", Path: name, Err: fs.ErrInvalid}
+	}
+
+	lost := context.Cause(held.ctx)
+	held.stop(nil)
+	if err = errors.Join(lost, gdrive.removeLock(node.Id)); err != nil {
+		return &fs.PathError{Op: "unlock", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Release all locks held by client
+func (gdrive *Gdrive) unlockAll() error {
+	gdrive.locks.locker.Lock()
+	held := maps.Clone(gdrive.locks.held)
+	clear(gdrive.locks.held)
+	gdrive.locks.locker.Unlock()
+
+	errs := []error{}
+	for fileID, lock := range held {
+		lock.stop(nil)
+		errs = append(errs, gdrive.removeLock(fileID))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestLockRenewFail(t *testing.T) {
+	node, renews := &drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain"}, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/files"):
+			json.NewEncoder(w).Encode(&drive.FileList{Files: []*drive.File{node}})
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(node)
+		case r.Method == http.MethodPatch:
+			if renews++; renews > 1 {
+				w.WriteHeader(http.StatusInternalServerError) // Renew fail
+				return
+			}
+			json.NewEncoder(w).Encode(node)
+		}
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	gdrive := &Gdrive{
+		driveService: service,
+		rootDrive:    &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder},
+		locks:        &lockTable{held: map[string]*heldLock{}},
+		lockOwner:    "test",
+	}
+
+	if err := gdrive.Lock("file.txt", true, time.Millisecond*20); err != nil {
+		t.Fatal(err)
+	}
+	gdrive.locks.locker.Lock()
+	held := gdrive.locks.held[node.Id]
+	gdrive.locks.locker.Unlock()
+	select {
+	case <-held.ctx.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("lock renew not stopped after fail")
+	}
+
+	if err := gdrive.Unlock("file.txt"); err == nil || !strings.Contains(err.Error(), "lock lost") {
+		t.Errorf("lock lost not returned by Unlock: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package drivefs
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"path"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Save permission bits in properties, shortcut is followed and target changed like chmod(2)
+func (gdrive *Gdrive) Chmod(name string, mode fs.FileMode) error {
+	mode &= fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	properties := map[string]string{UnixModeProperties: strconv.Itoa(int(mode))}
+
+	name = pathManipulate(name).CleanPath()
+	if node, err := gdrive.getNode(name); err == nil && node.MimeType == GoogleDriveMimeSyslink {
+		if node, err = gdrive.followShortcut(node); err != nil {
+			return &fs.PathError{Op: "chmod", Path: name, Err: err}
+		} else if err = gdrive.updateMetadata("", node, &pendingMetadata{Properties: properties}); err != nil {
+			return &fs.PathError{Op: "chmod", Path: name, Err: err}
+		}
+		return nil
+	}
+	return gdrive.setMetadata("chmod", name, properties, time.Time{})
+}
+
+// Save owner user and group in properties, -1 keep current value
+func (gdrive *Gdrive) Chown(name string, uid, gid int) error {
+	properties := map[string]string{}
+	if uid >= 0 {
+		properties[UnixUidProperties] = strconv.Itoa(uid)
+	}
+	if gid >= 0 {
+		properties[UnixGidProperties] = strconv.Itoa(gid)
+	}
+	return gdrive.setMetadata("chown", name, properties, time.Time{})
+}
+
+// Save mtime in modifiedTime, Google Drive not have access time so atime is ignored
+func (gdrive *Gdrive) Chtimes(name string, atime, mtime time.Time) error {
+	return gdrive.setMetadata("chtimes", name, nil, mtime)
+}
+
+// Update properties and modifiedTime if mtime is not zero.
+//
+// Files in write-back journal are changed after upload, upload in progress would replace metadata
+func (gdrive *Gdrive) setMetadata(op, name string, properties map[string]string, mtime time.Time) error {
+	name = pathManipulate(name).CleanPath()
+	if gdrive.journal != nil && gdrive.journal.find(path.Join(gdrive.SubDir, name)) != nil {
+		if err := gdrive.journal.addMetadata(path.Join(gdrive.SubDir, name), properties, mtime); err != nil {
+			return &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		gdrive.journal.notify() // Apply now if upload finished after find
+		return nil
+	}
+
+	node, err := gdrive.getNode(name)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: ProcessErr(fileRes(node), err)}
+	} else if err = gdrive.updateMetadata(path.Join(gdrive.SubDir, name), node, &pendingMetadata{properties, mtime}); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// Send metadata to node and save node updated in cache key, empty key to not cache
+func (gdrive *Gdrive) updateMetadata(key string, node *drive.File, metadata *pendingMetadata) error {
+	if err := gdrive.ensureCreated(node.Id); err != nil {
+		return err
+	}
+
+	update := &drive.File{Properties: metadata.Properties}
+	if !metadata.ModifiedTime.IsZero() {
+		update.ModifiedTime = metadata.ModifiedTime.UTC().Format(time.RFC3339Nano)
+	}
+	res, err := gdrive.driveService.Files.Update(node.Id, update).Fields("*").Do()
+	if err != nil {
+		return ProcessErr(fileRes(res), err)
+	}
+	if key != "" {
+		gdrive.cacheUploaded(key, res)
+	}
+	return nil
+}
+
+// Metadata changed while file is in write-back journal
+type pendingMetadata struct {
+	Properties   map[string]string `json:"properties,omitempty"`
+	ModifiedTime time.Time         `json:"modified_time,omitzero"`
+}
+
+// Merge metadata to apply after upload of name
+func (journal *journal) addMetadata(name string, properties map[string]string, mtime time.Time) error {
+	journal.locker.Lock()
+	defer journal.locker.Unlock()
+	metadata, err := journal.metadata.Get(name)
+	if err != nil || metadata == nil {
+		metadata = &pendingMetadata{}
+	}
+	if metadata.Properties == nil {
+		metadata.Properties = map[string]string{}
+	}
+	maps.Copy(metadata.Properties, properties)
+	if !mtime.IsZero() {
+		metadata.ModifiedTime = mtime
+	}
+	return journal.metadata.Set(JournalTime, name, metadata)
+}
+
+// Apply metadata of files without uploads in journal, failed updates are retried by next call.
+//
+// Return error only if journal cannot be updated
+func (gdrive *Gdrive) flushMetadata() error {
+	gdrive.journal.locker.Lock()
+	defer gdrive.journal.locker.Unlock()
+	values, err := gdrive.journal.metadata.Values()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for name, metadata := range maps.Collect(values) {
+		if gdrive.journal.find(name) != nil {
+			continue // Wait upload, including failed uploads waiting RetryUpload
+		}
+		if node, err := gdrive.getNode(name); errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, gdrive.journal.metadata.Delete(name)) // File removed
+		} else if err == nil && gdrive.updateMetadata(name, node, metadata) == nil {
+			errs = append(errs, gdrive.journal.metadata.Delete(name))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,128 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"sirherobrine23.com.br/Sirherobrine23/drivefs/cache"
+)
+
+// Fake Drive with one file in root, PATCH merge properties and modifiedTime
+func metadataServer(t *testing.T, node *drive.File) (*Gdrive, *int) {
+	updates := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(&drive.FileList{Files: []*drive.File{node}})
+		case http.MethodPatch:
+			*updates++
+			var update drive.File
+			json.NewDecoder(r.Body).Decode(&update)
+			for key, value := range update.Properties {
+				node.Properties[key] = value
+			}
+			if update.ModifiedTime != "" {
+				node.ModifiedTime = update.ModifiedTime
+			}
+			json.NewEncoder(w).Encode(node)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	service, _ := testService(t, server)
+	return &Gdrive{driveService: service, rootDrive: &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder}}, updates
+}
+
+func TestSetMetadata(t *testing.T) {
+	node := &drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Properties: map[string]string{}}
+	gdrive, _ := metadataServer(t, node)
+
+	var err error
+	mtime := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err = gdrive.Chmod("file.txt", 0640); err != nil {
+		t.Fatal(err)
+	} else if err = gdrive.Chown("file.txt", 1000, -1); err != nil {
+		t.Fatal(err)
+	} else if err = gdrive.Chtimes("file.txt", time.Time{}, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	stat := &NodeStat{File: node}
+	if mode := stat.Mode(); mode != 0640 {
+		t.Errorf("mode %s, expected -rw-r-----", mode)
+	}
+	if uid, gid := stat.Owner(); uid != 1000 || gid != -1 {
+		t.Errorf("owner %d:%d, expected 1000:-1", uid, gid)
+	}
+	if modTime := stat.ModTime(); !modTime.Equal(mtime) {
+		t.Errorf("mtime %s, expected %s", modTime, mtime)
+	}
+}
+
+func TestSetMetadataJournal(t *testing.T) {
+	node := &drive.File{Id: "file", Name: "file.txt", MimeType: "text/plain", Properties: map[string]string{}}
+	gdrive, updates := metadataServer(t, node)
+
+	dir := t.TempDir()
+	gdrive.journal = &journal{dir: dir, wake: make(chan struct{}, 1)}
+	db, err := cache.OpenSqliteDB(filepath.Join(dir, journalDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if gdrive.journal.entries, err = cache.NewSqlite[*JournalEntry](db, "journal"); err != nil {
+		t.Fatal(err)
+	} else if gdrive.journal.metadata, err = cache.NewSqlite[*pendingMetadata](db, "journal_metadata"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload in progress, metadata wait upload finish
+	entry, file, err := gdrive.journal.create("file.txt", node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if err = gdrive.Chmod("file.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = gdrive.flushMetadata(); err != nil {
+		t.Fatal(err)
+	} else if *updates != 0 {
+		t.Fatalf("metadata sent while file in journal")
+	}
+
+	if err = gdrive.journal.remove(entry); err != nil {
+		t.Fatal(err)
+	} else if err = gdrive.flushMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if mode := (&NodeStat{File: node}).Mode(); mode != 0600 {
+		t.Errorf("mode %s after upload, expected -rw-------", mode)
+	}
+	if values, _ := gdrive.journal.metadata.Values(); len(maps.Collect(values)) != 0 {
+		t.Errorf("metadata not removed after applied")
+	}
+}
+
+func TestNodeStatModeType(t *testing.T) {
+	for _, check := range []struct {
+		mimeType string
+		want     fs.FileMode
+	}{
+		{GoogleDriveMimeSyslink, fs.ModeSymlink | 0777},
+		{GoogleDriveMimeFolder, fs.ModeDir | 0750},
+		{"text/plain", 0750},
+	} {
+		node := &drive.File{MimeType: check.mimeType, Properties: map[string]string{UnixModeProperties: "488"}} // 0750
+		if mode := (&NodeStat{File: node}).Mode(); mode != check.want {
+			t.Errorf("%s: mode %s, expected %s", check.mimeType, mode, check.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package drivefs
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Detect content mime type from file name and first bytes written, return empty string to use default detect
+type MimeDetector func(name string, head []byte) string
+
+// Mime types to extensions not in all systems mime.types
+var MimeExtensions = map[string]string{
+	".csv":  "text/csv",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".md":   "text/markdown",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".rtf":  "application/rtf",
+	".tsv":  "text/tab-separated-values",
+	".txt":  "text/plain",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".zip":  "application/zip",
+}
+
+// Detect mime type from file extension, and content if extension is unknown
+func DetectMime(name string, head []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if mimeType, ok := MimeExtensions[ext]; ok {
+		return mimeType
+	} else if mimeType = mime.TypeByExtension(ext); mimeType != "" {
+		mimeType, _, _ = strings.Cut(mimeType, ";")
+		return mimeType
+	} else if len(head) > 0 {
+		mimeType, _, _ = strings.Cut(http.DetectContentType(head), ";")
+		return mimeType
+	}
+	return GoogleDriveMimeFile
+}
+
+// Detect mime type with MimeDetector and fallback to DetectMime
+func (gdrive *Gdrive) detectMime(name string, head []byte) string {
+	if gdrive.MimeDetector != nil {
+		if mimeType := gdrive.MimeDetector(name, head); mimeType != "" {
+			return mimeType
+		}
+	}
+	return DetectMime(name, head)
+}
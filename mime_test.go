@@ -0,0 +1,29 @@
+package drivefs
+
+import "testing"
+
+func TestDetectMime(t *testing.T) {
+	for _, test := range [][3]string{
+		{"report.PDF", "", "application/pdf"},
+		{"budget.xlsx", "", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"image", "\x89PNG\x0D\x0A\x1A\x0A", "image/png"},
+		{"notes", "Google drive", "text/plain"},
+		{"empty", "", GoogleDriveMimeFile},
+	} {
+		if mimeType := DetectMime(test[0], []byte(test[1])); mimeType != test[2] {
+			t.Errorf("invalid mime to %q: %q != %q", test[0], mimeType, test[2])
+		}
+	}
+
+	gdrive := &Gdrive{MimeDetector: func(name string, head []byte) string {
+		if name == "custom.bin" {
+			return "application/x-custom"
+		}
+		return ""
+	}}
+	if mimeType := gdrive.detectMime("custom.bin", nil); mimeType != "application/x-custom" {
+		t.Errorf("MimeDetector not used: %q", mimeType)
+	} else if mimeType = gdrive.detectMime("report.pdf", nil); mimeType != "application/pdf" {
+		t.Errorf("invalid fallback mime: %q", mimeType)
+	}
+}
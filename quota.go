@@ -0,0 +1,154 @@
+package drivefs
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	QuotaRateWait  = time.Minute     // First pause by rate limit without Retry-After, doubled on each pause
+	QuotaDailyWait = time.Hour * 24  // Pause by daily limit, upload cap is a rolling 24 hours window
+	QuotaMaxWait   = time.Minute * 5 // Longest pause waited by uploads outside journal, longer pauses return QuotaError
+)
+
+var (
+	QuotaRateReasons  = []string{"userRateLimitExceeded", "rateLimitExceeded"}                 // Reasons of short limits, also returned by upload cap
+	QuotaDailyReasons = []string{"dailyLimitExceeded", "quotaExceeded", "uploadLimitExceeded"} // Reasons of daily limits
+)
+
+// Google Drive quota exceeded, uploads paused until Resume
+type QuotaError struct {
+	Reason string    // Google Drive error reason
+	Resume time.Time // Estimated time to uploads work again
+}
+
+func (err *QuotaError) Error() string {
+	if err.Resume.IsZero() {
+		return fmt.Sprintf("google drive quota exceeded: %s", err.Reason)
+	}
+	return fmt.Sprintf("google drive quota exceeded: %s, resume at %s", err.Reason, err.Resume.Format(time.RFC3339))
+}
+
+// Keep errors.Is(err, fs.ErrPermission) of previous ProcessErr
+func (err *QuotaError) Is(target error) bool { return target == fs.ErrPermission }
+
+// Return QuotaError if err reason is quota limit
+func quotaError(err *googleapi.Error) *QuotaError {
+	for _, item := range err.Errors {
+		if slices.Contains(QuotaRateReasons, item.Reason) || slices.Contains(QuotaDailyReasons, item.Reason) {
+			quota := &QuotaError{Reason: item.Reason}
+			if seconds, convErr := strconv.Atoi(err.Header.Get("Retry-After")); convErr == nil {
+				quota.Resume = time.Now().Add(time.Second * time.Duration(seconds))
+			} else if date, convErr := http.ParseTime(err.Header.Get("Retry-After")); convErr == nil {
+				quota.Resume = date
+			}
+			return quota
+		}
+	}
+	return nil
+}
+
+// Uploads pause state, shared with Sub
+type quotaState struct {
+	locker sync.Mutex
+	paused *QuotaError   // Last quota error, nil if uploads not paused
+	pauses int           // Rate limit pauses without upload between
+	closed chan struct{} // Closed by Gdrive.Close to stop waits
+}
+
+// Stop uploads waiting resume, they return QuotaError
+func (state *quotaState) close() {
+	state.locker.Lock()
+	defer state.locker.Unlock()
+	select {
+	case <-state.closed:
+	default:
+		if state.closed != nil {
+			close(state.closed)
+		}
+	}
+}
+
+// Pause uploads if err is QuotaError and return err
+func (gdrive *Gdrive) pauseUploads(err error) error {
+	quota, ok := err.(*QuotaError)
+	if !ok || gdrive.quota == nil {
+		return err
+	}
+
+	gdrive.quota.locker.Lock()
+	defer gdrive.quota.locker.Unlock()
+	if quota.Resume.IsZero() {
+		if slices.Contains(QuotaDailyReasons, quota.Reason) {
+			quota.Resume = time.Now().Add(QuotaDailyWait)
+		} else {
+			// Rate limit repeated after resume is probably the daily upload cap
+			quota.Resume = time.Now().Add(min(QuotaDailyWait, QuotaRateWait<<min(gdrive.quota.pauses, 11)))
+			gdrive.quota.pauses++
+		}
+	}
+
+	gdrive.quota.paused = quota
+	log.Printf("drivefs: uploads paused by %s until %s", quota.Reason, quota.Resume.Format(time.RFC3339))
+	return quota
+}
+
+// Call send again after uploads resume while it fail by quota.
+//
+// Journal sessions, pauses longer than QuotaMaxWait and waits stopped by Close return QuotaError
+func (gdrive *Gdrive) waitQuota(session *UploadSession, send func() error) error {
+	for {
+		err := send()
+		quota, ok := err.(*QuotaError)
+		if !ok || session.queued || gdrive.quota == nil || time.Until(quota.Resume) > QuotaMaxWait {
+			return err
+		}
+
+		select {
+		case <-gdrive.quota.closed:
+			return err
+		case <-time.After(time.Until(quota.Resume)):
+		}
+	}
+}
+
+// Return QuotaError if uploads are paused
+func (gdrive *Gdrive) uploadPaused() error {
+	if gdrive.quota == nil {
+		return nil
+	}
+	gdrive.quota.locker.Lock()
+	defer gdrive.quota.locker.Unlock()
+	if gdrive.quota.paused != nil && time.Now().Before(gdrive.quota.paused.Resume) {
+		return gdrive.quota.paused
+	}
+	return nil
+}
+
+// Upload finished, reset rate limit pauses
+func (gdrive *Gdrive) uploadDone() {
+	if gdrive.quota == nil {
+		return
+	}
+	gdrive.quota.locker.Lock()
+	defer gdrive.quota.locker.Unlock()
+	gdrive.quota.paused, gdrive.quota.pauses = nil, 0
+}
+
+// Return quota error pausing uploads, nil if uploads are working.
+//
+// Uploads wait up to QuotaMaxWait or stay in write-back journal until QuotaError.Resume, reads are not paused
+func (gdrive *Gdrive) UploadQuota() *QuotaError {
+	if err := gdrive.uploadPaused(); err != nil {
+		return err.(*QuotaError)
+	}
+	return nil
+}
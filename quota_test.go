@@ -0,0 +1,91 @@
+package drivefs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestUploadQuotaResume(t *testing.T) {
+	starts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if starts++; starts == 1 {
+				w.Header().Set("Retry-After", "0") // Resume now
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, `{"error":{"code":403,"errors":[{"reason":"userRateLimitExceeded"}]}}`)
+				return
+			}
+			w.Header().Set("Location", "http://"+r.Host+"/session")
+		case http.MethodPut:
+			io.Copy(io.Discard, r.Body)
+			io.WriteString(w, `{"id":"file","name":"file.txt"}`)
+		}
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	gdrive := &Gdrive{client: &http.Client{Transport: serverTransport{serverURL}}, quota: &quotaState{}}
+	session := gdrive.newSession(&drive.File{Name: "file.txt", MimeType: "text/plain"}, nil)
+	node, err := gdrive.upload(session, strings.NewReader("Google drive"))
+	if err != nil {
+		t.Fatalf("upload not resumed after quota: %s", err)
+	} else if node.Id != "file" || starts != 2 {
+		t.Errorf("node %q after %d session starts", node.Id, starts)
+	} else if quota := gdrive.UploadQuota(); quota != nil {
+		t.Errorf("uploads still paused: %s", quota)
+	}
+
+	// Journal retry in worker
+	starts = 0
+	session = gdrive.newSession(&drive.File{Name: "file.txt", MimeType: "text/plain"}, nil)
+	session.queued = true
+	if _, err = gdrive.upload(session, strings.NewReader("Google drive")); err == nil {
+		t.Errorf("quota error not returned to journal")
+	} else if _, ok := err.(*QuotaError); !ok {
+		t.Errorf("expected QuotaError, returned %v", err)
+	}
+}
+
+func TestUploadQuotaWaitStop(t *testing.T) {
+	retryAfter := "3600"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", retryAfter)
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"error":{"code":403,"errors":[{"reason":"userRateLimitExceeded"}]}}`)
+	}))
+	defer server.Close()
+
+	// Pause longer than QuotaMaxWait returned without wait
+	_, client := testService(t, server)
+	gdrive := &Gdrive{client: client, quota: &quotaState{closed: make(chan struct{})}}
+	session := gdrive.newSession(&drive.File{Name: "file.txt", MimeType: "text/plain"}, nil)
+	if _, err := gdrive.upload(session, strings.NewReader("Google drive")); err == nil {
+		t.Fatal("quota error not returned")
+	}
+
+	// Wait stopped by Close
+	retryAfter, gdrive.quota = "60", &quotaState{closed: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		_, err := gdrive.upload(gdrive.newSession(&drive.File{Name: "file.txt", MimeType: "text/plain"}, nil), strings.NewReader("Google drive"))
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	gdrive.quota.close()
+	select {
+	case err := <-done:
+		if _, ok := err.(*QuotaError); !ok {
+			t.Errorf("expected QuotaError, returned %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("upload still waiting quota after close")
+	}
+}
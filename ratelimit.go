@@ -0,0 +1,157 @@
+package drivefs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Rate limits active in time of day
+type RateSchedule struct {
+	Start    string `json:"start"`              // Local time "15:04", schedule with Start after End cross midnight
+	End      string `json:"end"`                // Local time "15:04"
+	Upload   int64  `json:"upload,omitempty"`   // Bytes per second, 0 to unlimited
+	Download int64  `json:"download,omitempty"` // Bytes per second, 0 to unlimited
+}
+
+// Upload and download rate limits in bytes per second, 0 to unlimited
+type RateLimit struct {
+	Upload       int64          `json:"upload,omitempty"`        // Limit of all uploads
+	Download     int64          `json:"download,omitempty"`      // Limit of all downloads
+	FileUpload   int64          `json:"file_upload,omitempty"`   // Limit of each upload
+	FileDownload int64          `json:"file_download,omitempty"` // Limit of each open file or DownloadTo
+	Schedule     []RateSchedule `json:"schedule,omitempty"`      // First schedule active replace Upload and Download
+}
+
+// Return true if t is in schedule time
+func (schedule RateSchedule) active(t time.Time) bool {
+	start, _ := time.Parse("15:04", schedule.Start)
+	end, _ := time.Parse("15:04", schedule.End)
+	now := t.Hour()*60 + t.Minute()
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from <= to {
+		return from <= now && now < to
+	}
+	return now >= from || now < to
+}
+
+// Return global limits to t
+func (limit RateLimit) current(t time.Time) (upload, download int64) {
+	for _, schedule := range limit.Schedule {
+		if schedule.active(t) {
+			return schedule.Upload, schedule.Download
+		}
+	}
+	return limit.Upload, limit.Download
+}
+
+// Token bucket with one second of burst, rate given in each wait
+type tokenBucket struct {
+	locker sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Take n tokens and sleep until bucket is not in debt
+func (bucket *tokenBucket) wait(rate int64, n int) {
+	if rate <= 0 || n <= 0 {
+		return
+	}
+
+	bucket.locker.Lock()
+	now := time.Now()
+	if !bucket.last.IsZero() {
+		bucket.tokens = min(float64(rate), bucket.tokens+now.Sub(bucket.last).Seconds()*float64(rate))
+	}
+	bucket.last = now
+	bucket.tokens -= float64(n)
+	debt := -bucket.tokens
+	bucket.locker.Unlock()
+
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / float64(rate) * float64(time.Second)))
+	}
+}
+
+// Global buckets and limits, shared with Sub
+type rateLimiter struct {
+	locker           sync.RWMutex
+	limit            RateLimit
+	upload, download tokenBucket
+}
+
+// Replace rate limits, safe to call while files are open
+func (gdrive *Gdrive) SetRateLimit(limit RateLimit) error {
+	for _, schedule := range limit.Schedule {
+		for _, value := range []string{schedule.Start, schedule.End} {
+			if _, err := time.Parse("15:04", value); err != nil {
+				return fmt.Errorf("invalid schedule time %q: %w", value, err)
+			}
+		}
+	}
+
+	gdrive.rate.locker.Lock()
+	defer gdrive.rate.locker.Unlock()
+	gdrive.rate.limit = limit
+	return nil
+}
+
+// Return current rate limits
+func (gdrive *Gdrive) RateLimit() RateLimit {
+	gdrive.rate.locker.RLock()
+	defer gdrive.rate.locker.RUnlock()
+	return gdrive.rate.limit
+}
+
+// Wait global and file bucket to upload n bytes
+func (gdrive *Gdrive) waitUpload(file *tokenBucket, n int) {
+	if gdrive.rate == nil {
+		return
+	}
+	limit := gdrive.RateLimit()
+	upload, _ := limit.current(time.Now())
+	gdrive.rate.upload.wait(upload, n)
+	if file != nil {
+		file.wait(limit.FileUpload, n)
+	}
+}
+
+// Wait global and file bucket after download n bytes
+func (gdrive *Gdrive) waitDownload(file *tokenBucket, n int) {
+	if gdrive.rate == nil {
+		return
+	}
+	limit := gdrive.RateLimit()
+	_, download := limit.current(time.Now())
+	gdrive.rate.download.wait(download, n)
+	if file != nil {
+		file.wait(limit.FileDownload, n)
+	}
+}
+
+// Download body limited by global and file rate limit
+type throttledReader struct {
+	io.ReadCloser
+	client *Gdrive
+	file   *tokenBucket
+}
+
+func (reader *throttledReader) Read(p []byte) (n int, err error) {
+	n, err = reader.ReadCloser.Read(p)
+	reader.client.waitDownload(reader.file, n)
+	return
+}
+
+// Upload body limited by global and file rate limit
+type throttledUpload struct {
+	io.Reader
+	client *Gdrive
+	file   *tokenBucket
+}
+
+func (reader *throttledUpload) Read(p []byte) (n int, err error) {
+	n, err = reader.Reader.Read(p)
+	reader.client.waitUpload(reader.file, n)
+	return
+}
@@ -0,0 +1,33 @@
+package drivefs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitSchedule(t *testing.T) {
+	limit := RateLimit{
+		Upload:   1 << 20,
+		Download: 2 << 20,
+		Schedule: []RateSchedule{
+			{Start: "09:00", End: "18:00", Upload: 10 << 20, Download: 10 << 20},
+			{Start: "22:00", End: "06:00"},
+		},
+	}
+
+	for _, check := range []struct {
+		clock            string
+		upload, download int64
+	}{
+		{"10:30", 10 << 20, 10 << 20},
+		{"18:00", 1 << 20, 2 << 20},
+		{"23:15", 0, 0},
+		{"05:59", 0, 0},
+		{"07:00", 1 << 20, 2 << 20},
+	} {
+		now, _ := time.Parse("15:04", check.clock)
+		if upload, download := limit.current(now); upload != check.upload || download != check.download {
+			t.Errorf("%s: limits %d/%d, expected %d/%d", check.clock, upload, download, check.upload, check.download)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package drivefs
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	ReadAheadBlock          = 1 << 20 // Block fetched by read-ahead without block cache
+	DefaultReadAheadStreams = 2       // Parallel range requests of read-ahead
+	readAheadSequential     = 2       // Sequential reads to start read-ahead
+)
+
+// Block being fetched by read-ahead
+type prefetchBlock struct {
+	done    chan struct{} // Closed when fetched
+	data    []byte
+	err     error
+	cancel  context.CancelFunc
+	waiters int // Reads waiting block, locker of readAhead must be held
+}
+
+// Read-ahead of FileNode, fetch next bytes in background while reads are sequential
+type readAhead struct {
+	locker    sync.Mutex
+	file      *FileNode
+	blockSize int64
+	next      int64                    // Offset of next sequential read
+	streak    int                      // Sequential reads in a row
+	window    int64                    // Bytes fetched after read, grow to Gdrive.ReadAhead
+	blocks    map[int64]*prefetchBlock // Blocks fetched or being fetched
+	streams   chan struct{}            // Limit parallel range requests
+}
+
+func (gdrive *Gdrive) newReadAhead(file *FileNode) *readAhead {
+	ahead := &readAhead{file: file, blockSize: ReadAheadBlock, next: -1, blocks: map[int64]*prefetchBlock{}}
+	if gdrive.blocks != nil {
+		ahead.blockSize = gdrive.blocks.BlockSize // Share blocks with block cache
+	}
+	ahead.streams = make(chan struct{}, max(1, cmp.Or(gdrive.ReadAheadStreams, DefaultReadAheadStreams)))
+	return ahead
+}
+
+// Cancel all blocks being fetched
+func (ahead *readAhead) stop() {
+	ahead.locker.Lock()
+	defer ahead.locker.Unlock()
+	for _, block := range ahead.blocks {
+		block.cancel()
+	}
+	ahead.reset()
+}
+
+// Drop blocks and cancel fetch of blocks without reads waiting, locker must be held
+func (ahead *readAhead) reset() {
+	for _, block := range ahead.blocks {
+		if block.waiters == 0 {
+			block.cancel()
+		}
+	}
+	clear(ahead.blocks)
+	ahead.streak, ahead.window = 0, 0
+}
+
+// Read p from blocks fetched in background, return false if access is not sequential.
+//
+// If block fetch fail or is canceled return false with n bytes read, caller read rest of p
+func (ahead *readAhead) read(p []byte, off int64) (n int, ok bool, err error) {
+	size := ahead.file.Node.Size
+	end := min(off+int64(len(p)), size)
+
+	ahead.locker.Lock()
+	if off == ahead.next {
+		ahead.streak++
+	} else if ahead.streak > 0 || len(ahead.blocks) > 0 {
+		ahead.reset() // Random access
+	}
+	ahead.next = end
+	if ahead.streak < readAheadSequential {
+		ahead.locker.Unlock()
+		return 0, false, nil
+	}
+
+	// Grow window while reads continue sequential
+	ahead.window = min(max(ahead.window*2, 2*ahead.blockSize), max(ahead.file.Client.ReadAhead, ahead.blockSize))
+	for index := range ahead.blocks {
+		if (index+1)*ahead.blockSize <= off {
+			delete(ahead.blocks, index) // Already read
+		}
+	}
+	for index := off / ahead.blockSize; index*ahead.blockSize < min(end+ahead.window, size); index++ {
+		if _, ok := ahead.blocks[index]; !ok {
+			ahead.blocks[index] = ahead.fetch(index)
+		}
+	}
+
+	// Blocks to read, not canceled by reset of other read while waiting
+	blocks := []*prefetchBlock{}
+	for index := off / ahead.blockSize; index*ahead.blockSize < end; index++ {
+		block := ahead.blocks[index]
+		block.waiters++
+		blocks = append(blocks, block)
+	}
+	ahead.locker.Unlock()
+	defer ahead.release(off/ahead.blockSize, blocks)
+
+	for index, block := range blocks {
+		<-block.done
+		if block.err != nil {
+			return n, false, nil // Read rest without read-ahead
+		}
+		blockStart := (off/ahead.blockSize + int64(index)) * ahead.blockSize
+		n += copy(p[n:], block.data[off+int64(n)-blockStart:])
+	}
+
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, true, err
+}
+
+// Stop waiting blocks from first index, cancel blocks dropped by reset without other reads waiting
+func (ahead *readAhead) release(first int64, blocks []*prefetchBlock) {
+	ahead.locker.Lock()
+	defer ahead.locker.Unlock()
+	for index, block := range blocks {
+		if block.waiters--; block.waiters == 0 && ahead.blocks[first+int64(index)] != block {
+			block.cancel()
+		}
+	}
+}
+
+// Remove failed block if not replaced by reset
+func (ahead *readAhead) evict(index int64, block *prefetchBlock) {
+	ahead.locker.Lock()
+	defer ahead.locker.Unlock()
+	if ahead.blocks[index] == block {
+		delete(ahead.blocks, index)
+	}
+}
+
+// Fetch block in background, from block cache if have it
+func (ahead *readAhead) fetch(index int64) *prefetchBlock {
+	ctx, cancel := context.WithCancel(context.Background())
+	block, file := &prefetchBlock{done: make(chan struct{}), cancel: cancel}, ahead.file
+	key := file.blockKey()
+	if file.Client.blocks != nil && key != "" {
+		if block.data = file.Client.blocks.Get(key, index); block.data != nil {
+			close(block.done)
+			return block
+		}
+	}
+
+	go func() {
+		defer cancel()
+		defer func() {
+			if block.err != nil {
+				ahead.evict(index, block) // Fetch again in next read
+			}
+			close(block.done)
+		}()
+		select {
+		case <-ctx.Done():
+			block.err = ctx.Err()
+			return
+		case ahead.streams <- struct{}{}:
+			defer func() { <-ahead.streams }()
+		}
+
+		start := index * ahead.blockSize
+		stream, err := file.openStream(ctx, start, min(start+ahead.blockSize, file.Node.Size))
+		if err != nil {
+			block.err = err
+			return
+		}
+		defer stream.body.Close()
+
+		block.data = make([]byte, min(ahead.blockSize, file.Node.Size-start))
+		if _, err = file.readFull(stream, block.data); err != nil {
+			block.data, block.err = nil, ProcessErr(nil, err)
+		} else if file.Client.blocks != nil && key != "" {
+			file.Client.blocks.Set(key, index, block.data)
+		}
+	}()
+	return block
+}
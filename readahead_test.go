@@ -0,0 +1,83 @@
+package drivefs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestReadAheadEvictFailed(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(make([]byte, 10))
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	gdrive := &Gdrive{driveService: service}
+	ahead := gdrive.newReadAhead(&FileNode{Client: gdrive, Node: &drive.File{Id: "file", Size: 10}})
+	ahead.next, ahead.streak = 0, readAheadSequential
+
+	if _, ok, err := ahead.read(make([]byte, 10), 0); ok || err != nil {
+		t.Fatalf("failed fetch not left to caller: %v, %v", ok, err)
+	}
+	ahead.locker.Lock()
+	blocks := len(ahead.blocks)
+	ahead.locker.Unlock()
+	if blocks != 0 {
+		t.Fatalf("failed block kept in read-ahead")
+	}
+
+	fail.Store(false)
+	ahead.next = 0
+	if n, ok, err := ahead.read(make([]byte, 10), 0); n != 10 || !ok || err != nil {
+		t.Errorf("read after fail: %d, %v, %v", n, ok, err)
+	}
+}
+
+func TestReadAheadResetWaiting(t *testing.T) {
+	requested, release := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		<-release
+		w.Write(make([]byte, 10))
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	gdrive := &Gdrive{driveService: service}
+	ahead := gdrive.newReadAhead(&FileNode{Client: gdrive, Node: &drive.File{Id: "file", Size: 10}})
+	ahead.next, ahead.streak = 0, readAheadSequential
+
+	type result struct {
+		n   int
+		ok  bool
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		n, ok, err := ahead.read(make([]byte, 10), 0)
+		done <- result{n, ok, err}
+	}()
+
+	// Random read of other caller reset read-ahead while first read wait block
+	<-requested
+	if _, ok, _ := ahead.read(make([]byte, 2), 5); ok {
+		t.Errorf("random read served by read-ahead")
+	}
+	close(release)
+	if res := <-done; res.n != 10 || !res.ok || res.err != nil {
+		t.Errorf("waiting read canceled by reset: %d, %v, %v", res.n, res.ok, res.err)
+	}
+}
@@ -0,0 +1,36 @@
+package drivefs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// Send all requests to server, include resumable upload endpoint
+type serverTransport struct{ server *url.URL }
+
+func (transport serverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = transport.server.Scheme, transport.server.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Drive service and HTTP client sending every request to fake server
+func testService(t *testing.T, server *httptest.Server) (*drive.Service, *http.Client) {
+	t.Helper()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: serverTransport{serverURL}}
+	service, err := drive.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithHTTPClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service, client
+}
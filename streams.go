@@ -0,0 +1,166 @@
+package drivefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	rangeStreamsIdle = 4                      // Idle streams kept by file
+	rangeStreamSkip  = 256 << 10              // Max bytes discarded to reuse stream behind offset
+	downloadRetries  = 5                      // Reconnects of download stream
+	downloadBackoff  = time.Millisecond * 500 // First reconnect wait, doubled on each attempt
+)
+
+// Download stream at offset
+type rangeStream struct {
+	ctx    context.Context
+	offset int64
+	end    int64 // Range end, 0 to end of file
+	body   io.ReadCloser
+}
+
+// Independent range streams of FileNode, each ReadAt take one stream to itself
+type rangeStreams struct {
+	locker sync.Mutex
+	idle   []*rangeStream
+}
+
+// Take idle stream at off or behind it, nil if not have one
+func (streams *rangeStreams) take(off int64) *rangeStream {
+	streams.locker.Lock()
+	defer streams.locker.Unlock()
+	for index, stream := range streams.idle {
+		if stream.offset <= off && off-stream.offset <= rangeStreamSkip {
+			streams.idle = append(streams.idle[:index], streams.idle[index+1:]...)
+			return stream
+		}
+	}
+	return nil
+}
+
+// Return stream to idle, close oldest if have many
+func (streams *rangeStreams) put(stream *rangeStream) {
+	streams.locker.Lock()
+	defer streams.locker.Unlock()
+	if streams.idle = append(streams.idle, stream); len(streams.idle) > rangeStreamsIdle {
+		streams.idle[0].body.Close()
+		streams.idle = streams.idle[1:]
+	}
+}
+
+func (streams *rangeStreams) close() {
+	streams.locker.Lock()
+	defer streams.locker.Unlock()
+	for _, stream := range streams.idle {
+		stream.body.Close()
+	}
+	streams.idle = nil
+}
+
+// Open download stream from off to end, end 0 is end of file
+func (file *FileNode) openStream(ctx context.Context, off, end int64) (*rangeStream, error) {
+	get := file.Client.driveService.Files.Get(file.Node.Id).Context(ctx)
+	if end > 0 {
+		get.Header().Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+	} else if off > 0 {
+		get.Header().Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	res, err := openFileAPI(get)
+	if err != nil {
+		return nil, ProcessErr(httpRes(res), err)
+	}
+	body := &throttledReader{ReadCloser: res.Body, client: file.Client, file: &file.limit}
+	return &rangeStream{ctx: ctx, offset: off, end: end, body: body}, nil
+}
+
+// Check if remote content changed since node was seen
+func (file *FileNode) checkRevision() error {
+	remote, err := file.Client.driveService.Files.Get(file.Node.Id).Fields("headRevisionId,md5Checksum,size").Do()
+	if err != nil {
+		return ProcessErr(fileRes(remote), err)
+	}
+
+	switch {
+	case file.Node.HeadRevisionId != "" && remote.HeadRevisionId != file.Node.HeadRevisionId:
+		return fmt.Errorf("%w: revision %s, expected %s", ErrConflict, remote.HeadRevisionId, file.Node.HeadRevisionId)
+	case file.Node.Md5Checksum != "" && remote.Md5Checksum != file.Node.Md5Checksum:
+		return fmt.Errorf("%w: md5 %s, expected %s", ErrConflict, remote.Md5Checksum, file.Node.Md5Checksum)
+	case remote.Size != file.Node.Size:
+		return fmt.Errorf("%w: size %d, expected %d", ErrConflict, remote.Size, file.Node.Size)
+	}
+	return nil
+}
+
+// Return true if download can continue after err
+func retryDownload(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, ErrConflict) && retryUpload(err)
+}
+
+// Fill p from stream, reopen stream from last byte read if connection fail
+func (file *FileNode) readFull(stream *rangeStream, p []byte) (n int, err error) {
+	for attempt := 0; ; attempt++ {
+		read, readErr := io.ReadFull(stream.body, p[n:])
+		n, stream.offset = n+read, stream.offset+int64(read)
+		if err = readErr; err == nil || attempt >= downloadRetries || !retryDownload(err) {
+			return n, err
+		}
+
+		// Reconnect from stream offset if content not changed
+		stream.body.Close()
+		stream.body = io.NopCloser(errReader{err})
+		select {
+		case <-stream.ctx.Done():
+			return n, stream.ctx.Err()
+		case <-time.After(downloadBackoff << attempt):
+		}
+		if err = file.checkRevision(); err != nil && !retryDownload(err) {
+			return n, err
+		} else if err == nil {
+			if reopen, openErr := file.openStream(stream.ctx, stream.offset, stream.end); openErr == nil {
+				stream.body = reopen.body
+			} else if !retryDownload(openErr) {
+				return n, openErr
+			}
+		}
+	}
+}
+
+// Reader returning only err, to stream not reopened
+type errReader struct{ err error }
+
+func (reader errReader) Read([]byte) (int, error) { return 0, reader.err }
+
+// Read p from stream at off, stream is returned to idle streams after read
+func (file *FileNode) readStream(p []byte, off int64) (n int, err error) {
+	stream := file.streams.take(off)
+	if stream == nil {
+		if stream, err = file.openStream(context.Background(), off, 0); err != nil {
+			return 0, err
+		}
+	} else if stream.offset < off {
+		if _, err = io.CopyN(io.Discard, stream.body, off-stream.offset); err != nil {
+			stream.body.Close()
+			return 0, ProcessErr(nil, err)
+		}
+		stream.offset = off
+	}
+
+	n, err = file.readFull(stream, p[:min(int64(len(p)), file.Node.Size-off)])
+	if err != nil || stream.offset >= file.Node.Size {
+		stream.body.Close()
+	} else {
+		file.streams.put(stream)
+	}
+
+	if err != nil {
+		return n, ProcessErr(nil, err)
+	} else if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,106 @@
+package drivefs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const MaxShortcutDepth = 40 // Shortcuts followed before ELOOP, same as Linux MAXSYMLINKS
+
+// Follow shortcuts until node is not shortcut, return syscall.ELOOP after MaxShortcutDepth shortcuts
+// and fs.ErrInvalid to shortcut without target
+func (gdrive *Gdrive) followShortcut(node *drive.File) (*drive.File, error) {
+	for depth := 0; node.MimeType == GoogleDriveMimeSyslink; depth++ {
+		if node.ShortcutDetails == nil {
+			return nil, fs.ErrInvalid
+		} else if depth >= MaxShortcutDepth {
+			return nil, syscall.ELOOP
+		}
+
+		target, err := gdrive.driveService.Files.Get(node.ShortcutDetails.TargetId).Fields("*").Do()
+		if err != nil {
+			return nil, ProcessErr(fileRes(target), err)
+		} else if gdrive.isWorkspace(target) {
+			target = gdrive.workspaceNode(target)
+		}
+		node = target
+	}
+	return node, nil
+}
+
+// Return target relative to folder base, both paths from root
+func relativePath(base, target string) string {
+	split := func(name string) []string {
+		if name = pathManipulate(name).CleanPath(); name == "/" {
+			return nil
+		}
+		return strings.Split(name, "/")
+	}
+
+	baseParts, targetParts := split(base), split(target)
+	common := 0
+	for common < len(baseParts) && common < len(targetParts) && baseParts[common] == targetParts[common] {
+		common++
+	}
+	parts := append(slices.Repeat([]string{".."}, len(baseParts)-common), targetParts[common:]...)
+	if len(parts) == 0 {
+		return "."
+	}
+	return path.Join(parts...)
+}
+
+// Create shortcut link to target.
+//
+// Target is absolute path from root, path relative to link folder or "gdrive:<ID>" to file outside root
+func (gdrive *Gdrive) Symlink(target, link string) error {
+	link = pathManipulate(link).CleanPath()
+	if _, err := gdrive.getNode(link); err == nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: fs.ErrExist}
+	}
+
+	targetID, ok := strings.CutPrefix(target, "gdrive:")
+	if !ok {
+		name := target
+		if !path.IsAbs(name) {
+			name = path.Join(path.Dir(link), name)
+		}
+		if name = pathManipulate(name).CleanPath(); name == ".." || strings.HasPrefix(name, "../") {
+			return &os.LinkError{Op: "symlink", Old: target, New: link, Err: fs.ErrInvalid} // Outside root, only with gdrive:<ID>
+		}
+
+		node, err := gdrive.getNode(name)
+		if err != nil {
+			return &os.LinkError{Op: "symlink", Old: target, New: link, Err: ProcessErr(fileRes(node), err)}
+		} else if err = gdrive.ensureCreated(node.Id); err != nil {
+			return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+		}
+		targetID = node.Id
+	}
+
+	parent, err := gdrive.getNode(path.Dir(link))
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: ProcessErr(fileRes(parent), err)}
+	} else if err = gdrive.ensureCreated(parent.Id); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	shortcut := &drive.File{
+		Name:            path.Base(link),
+		MimeType:        GoogleDriveMimeSyslink,
+		Parents:         []string{parent.Id},
+		ShortcutDetails: &drive.FileShortcutDetails{TargetId: targetID},
+	}
+	node, err := gdrive.driveService.Files.Create(shortcut).Fields("*").Do()
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: ProcessErr(fileRes(node), err)}
+	} else if gdrive.cache != nil {
+		gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, link), node)
+	}
+	return nil
+}
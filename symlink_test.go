@@ -0,0 +1,105 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestFollowShortcutLoop(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		id := path.Base(r.URL.Path) // Shortcut to itself
+		json.NewEncoder(w).Encode(&drive.File{Id: id, MimeType: GoogleDriveMimeSyslink, ShortcutDetails: &drive.FileShortcutDetails{TargetId: id}})
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+
+	gdrive := &Gdrive{driveService: service}
+	link := &drive.File{Id: "link", MimeType: GoogleDriveMimeSyslink, ShortcutDetails: &drive.FileShortcutDetails{TargetId: "link"}}
+	if _, err := gdrive.followShortcut(link); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("expected ELOOP, returned %v", err)
+	} else if requests != MaxShortcutDepth {
+		t.Errorf("followed %d shortcuts, expected %d", requests, MaxShortcutDepth)
+	}
+}
+
+func TestFollowShortcutInvalid(t *testing.T) {
+	link := &drive.File{Id: "link", MimeType: GoogleDriveMimeSyslink}
+	if _, err := (&Gdrive{}).followShortcut(link); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected fs.ErrInvalid, returned %v", err)
+	}
+
+	gdrive := &Gdrive{rootDrive: &drive.File{Id: "root"}}
+	if target, err := gdrive.forwardPathResolve("root"); err != nil || target != "." {
+		t.Errorf("root resolved to %q, %v", target, err)
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	for _, check := range [][3]string{
+		{"/", "file.txt", "file.txt"},
+		{"dir", "dir/file.txt", "file.txt"},
+		{"dir/sub", "dir/file.txt", "../file.txt"},
+		{"dir", "other/file.txt", "../other/file.txt"},
+		{"dir", "dir", "."},
+	} {
+		if rel := relativePath(check[0], check[1]); rel != check[2] {
+			t.Errorf("%s from %s: %q, expected %q", check[1], check[0], rel, check[2])
+		}
+	}
+}
+
+func TestSymlinkRelative(t *testing.T) {
+	nodes := map[string]*drive.File{
+		"dir":    {Id: "dir", Name: "dir", MimeType: GoogleDriveMimeFolder, Parents: []string{"root"}},
+		"target": {Id: "target", Name: "target.txt", MimeType: "text/plain", Parents: []string{"dir"}},
+	}
+	var created drive.File
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&created)
+			created.Id = "link"
+			json.NewEncoder(w).Encode(&created)
+		case r.URL.Query().Has("q"):
+			list := &drive.FileList{}
+			for _, node := range nodes {
+				if strings.Contains(r.URL.Query().Get("q"), "name = '"+node.Name+"'") {
+					list.Files = append(list.Files, node)
+				}
+			}
+			json.NewEncoder(w).Encode(list)
+		default:
+			json.NewEncoder(w).Encode(nodes[path.Base(r.URL.Path)])
+		}
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	gdrive := &Gdrive{driveService: service, rootDrive: &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder}}
+	if err := gdrive.Symlink("../outside", "link"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("target outside root: %v", err)
+	}
+	if err := gdrive.Symlink("target.txt", "dir/link"); err != nil {
+		t.Fatal(err)
+	} else if created.ShortcutDetails.TargetId != "target" || created.Parents[0] != "dir" {
+		t.Fatalf("shortcut %+v not created in dir to target", created)
+	}
+
+	// Shortcut listed by name after create
+	created.Name, nodes["link"] = "link", &created
+	if target, err := gdrive.ReadLink("dir/link"); err != nil || target != "target.txt" {
+		t.Errorf("readlink %q, %v", target, err)
+	}
+}
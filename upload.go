@@ -0,0 +1,486 @@
+package drivefs
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"sirherobrine23.com.br/Sirherobrine23/drivefs/cache"
+)
+
+const (
+	DefaultChunkSize int64 = 8 << 20   // Default resumable upload chunk size
+	UploadChunkAlign int64 = 256 << 10 // Google Drive require chunks multiple of 256KiB
+
+	UploadSessionTime = time.Hour * 24 * 7 // Google Drive keep resumable sessions for one week
+	uploadRetries     = 5                  // Retries to send same chunk
+)
+
+var uploadBackoff = time.Second // First retry wait of chunk, doubled on each attempt
+
+// Resumable upload session, saved in cache to continue upload after process restart
+type UploadSession struct {
+	URI     string    `json:"uri"`               // Session URI returned by Google Drive
+	FileID  string    `json:"file_id,omitempty"` // Node to upload new revision, empty to create new file
+	Offset  int64     `json:"offset"`            // Bytes confirmed by Google Drive
+	Started time.Time `json:"started"`           // Session start time
+
+	Metadata     *drive.File `json:"metadata,omitempty"`      // Metadata sent on session start
+	ContentType  string      `json:"content_type,omitempty"`  // Content mime type
+	KeepRevision bool        `json:"keep_revision,omitempty"` // Keep uploaded revision forever
+
+	name    string       // File name to detect mime type
+	detect  bool         // Detect mime type from first chunk
+	hash    *uploadHash  // Hash of content uploaded
+	replace *drive.File  // Node to replace with AtomicRename
+	expect  *drive.File  // Node seen on open, commit fail if remote changed
+	limit   *tokenBucket // Rate limit of this upload
+	queued  bool         // Return QuotaError to journal instead of wait uploads resume
+	entry   string       // Journal entry uploading content
+}
+
+// Key of session in sessions database: journal entry, file ID or pre-generated ID of new file.
+//
+// Empty if session cannot be resumed, AtomicRename sessions need node to replace
+func (session *UploadSession) key() string {
+	if session.replace != nil {
+		return ""
+	} else if session.entry != "" {
+		return session.entry
+	} else if session.Metadata != nil {
+		return cmp.Or(session.FileID, session.Metadata.Id)
+	}
+	return session.FileID
+}
+
+// Options to create file and upload content
+type CreateOptions struct {
+	Flag                int               // Open flags, default is os.O_RDWR|os.O_CREATE|os.O_TRUNC
+	Perm                fs.FileMode       // File permission, default is 0666
+	MimeType            string            // Content mime type, default is GoogleDriveMimeFile
+	Description         string            // File description
+	Properties          map[string]string // Custom properties visible to all apps
+	AppProperties       map[string]string // Custom properties private to this app
+	ModifiedTime        time.Time         // Modified time, zero to Google Drive set upload time
+	KeepRevisionForever bool              // Keep uploaded revision forever
+	Convert             bool              // Convert content to Google Workspace format from ImportFormats
+	Atomic              AtomicMode        // Replace content mode, AtomicNone to use Gdrive.Atomic
+}
+
+// Return new [*drive.File] with options metadata
+func (options *CreateOptions) metadata() *drive.File {
+	node := &drive.File{Properties: map[string]string{}}
+	if options == nil {
+		return node
+	}
+
+	maps.Copy(node.Properties, options.Properties)
+	node.MimeType = options.MimeType
+	node.Description = options.Description
+	node.AppProperties = maps.Clone(options.AppProperties)
+	if !options.ModifiedTime.IsZero() {
+		node.ModifiedTime = options.ModifiedTime.UTC().Format(time.RFC3339Nano)
+	}
+	return node
+}
+
+// Return new upload session to node, if node not have ID file is created in upload
+func (gdrive *Gdrive) newSession(node *drive.File, options *CreateOptions) *UploadSession {
+	session := &UploadSession{FileID: node.Id, Metadata: options.metadata(), ContentType: node.MimeType, name: node.Name, limit: &tokenBucket{}}
+	if options != nil {
+		session.KeepRevision = options.KeepRevisionForever
+		if options.MimeType != "" {
+			session.ContentType = options.MimeType
+		}
+	}
+
+	// Detect mime type if not have one
+	if session.detect = session.ContentType == "" || session.ContentType == GoogleDriveMimeFile; session.detect {
+		session.ContentType = GoogleDriveMimeFile
+	}
+
+	if node.Id != "" {
+		session.expect = node
+	}
+
+	if session.FileID != "" && gdrive.creates.claim(session.FileID) {
+		session.FileID, session.expect = "", nil
+		session.Metadata.Id = node.Id // Create with pre-generated ID, placeholder kept until upload commit
+	}
+
+	if session.FileID == "" {
+		session.Metadata.Name = node.Name
+		session.Metadata.Parents = node.Parents
+		session.Metadata.MimeType = node.MimeType
+		maps.Copy(session.Metadata.Properties, node.Properties)
+	} else if gdrive.atomicMode(options) == AtomicRename {
+		tempSession(session, node)
+	}
+	return session
+}
+
+// Release deferred create claimed by session, create worker send it if upload failed
+func (gdrive *Gdrive) finishCreate(session *UploadSession, err *error) {
+	if session.FileID == "" && session.Metadata != nil && session.Metadata.Id != "" {
+		*err = errors.Join(*err, gdrive.creates.finish(session.Metadata.Id, *err == nil))
+	}
+}
+
+// Upload running in background
+type pendingUpload struct {
+	done chan struct{} // Closed when upload finish
+	node *drive.File   // Node returned by Google Drive
+	err  error         // Upload error
+}
+
+// Write handles opened and uploads running in background
+type uploadTracker struct {
+	locker sync.Mutex
+	wait   sync.WaitGroup
+	files  map[io.Closer]struct{}
+}
+
+func (tracker *uploadTracker) add(file io.Closer) {
+	tracker.locker.Lock()
+	defer tracker.locker.Unlock()
+	tracker.files[file] = struct{}{}
+}
+
+func (tracker *uploadTracker) remove(file io.Closer) {
+	tracker.locker.Lock()
+	defer tracker.locker.Unlock()
+	delete(tracker.files, file)
+}
+
+// Check if upload error can be retried from last confirmed byte
+func retryUpload(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= http.StatusInternalServerError || apiErr.Code == http.StatusTooManyRequests || apiErr.Code == http.StatusRequestTimeout
+	}
+	return true // Network errors
+}
+
+// Return chunk size aligned to 256KiB
+func (gdrive *Gdrive) uploadChunkSize() int64 {
+	if gdrive.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return max(UploadChunkAlign, gdrive.ChunkSize-gdrive.ChunkSize%UploadChunkAlign)
+}
+
+// Start resumable session, create file if session not have FileID
+func (gdrive *Gdrive) startUpload(session *UploadSession) error {
+	if err := gdrive.ensureCreated(append([]string{session.FileID}, session.Metadata.Parents...)...); err != nil {
+		return err
+	}
+
+	metadata, err := json.Marshal(session.Metadata)
+	if err != nil {
+		return err
+	}
+
+	method, uploadURL := http.MethodPost, GoogleUploadURL+"?uploadType=resumable&fields=*"
+	if session.FileID != "" {
+		method, uploadURL = http.MethodPatch, fmt.Sprintf("%s/%s?uploadType=resumable&fields=*", GoogleUploadURL, session.FileID)
+	}
+	if session.KeepRevision {
+		uploadURL += "&keepRevisionForever=true"
+	}
+
+	req, err := http.NewRequest(method, uploadURL, bytes.NewReader(metadata))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", session.ContentType)
+
+	res, err := gdrive.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err = googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+
+	if session.URI, session.Started = res.Header.Get("Location"), time.Now(); session.URI == "" {
+		return errors.New("google drive not returned upload session")
+	}
+	return nil
+}
+
+// Send chunk to session, final chunk commit upload and return new node.
+//
+// Send empty chunk without final to get bytes confirmed by Google Drive
+func (gdrive *Gdrive) sendChunk(session *UploadSession, chunk []byte, final bool) (*drive.File, error) {
+	total := "*"
+	if final {
+		total = strconv.FormatInt(session.Offset+int64(len(chunk)), 10)
+	}
+
+	contentRange := "bytes */" + total
+	if len(chunk) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%s", session.Offset, session.Offset+int64(len(chunk))-1, total)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, session.URI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	if len(chunk) > 0 {
+		req.Body, req.ContentLength = io.NopCloser(&throttledUpload{Reader: bytes.NewReader(chunk), client: gdrive, file: session.limit}), int64(len(chunk))
+	}
+
+	res, err := gdrive.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// Resume Incomplete, Range header have bytes confirmed
+	if res.StatusCode == http.StatusPermanentRedirect {
+		session.Offset = 0
+		if _, end, ok := strings.Cut(res.Header.Get("Range"), "-"); ok {
+			if session.Offset, err = strconv.ParseInt(end, 10, 64); err != nil {
+				return nil, err
+			}
+			session.Offset++
+		}
+		return nil, nil
+	} else if err = googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+
+	node := &drive.File{}
+	if err = json.NewDecoder(res.Body).Decode(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Send chunk and retry from last byte confirmed if fail
+func (gdrive *Gdrive) sendRetry(session *UploadSession, chunk []byte, final bool) (node *drive.File, err error) {
+	start := session.Offset
+	for attempt := 0; ; attempt++ {
+		if node, err = gdrive.sendChunk(session, chunk[session.Offset-start:], final); err == nil {
+			break
+		} else if attempt >= uploadRetries || !retryUpload(err) {
+			return nil, err
+		}
+
+		<-time.After(uploadBackoff << attempt)
+		if node, err = gdrive.sendChunk(session, nil, false); err != nil {
+			continue // Check status in next attempt
+		} else if node != nil {
+			return node, nil // Upload finished before error
+		} else if session.Offset < start || session.Offset > start+int64(len(chunk)) {
+			return nil, fmt.Errorf("google drive confirmed %d bytes, outside of chunk", session.Offset)
+		}
+	}
+
+	if node == nil && gdrive.sessions != nil && session.key() != "" {
+		if err = gdrive.sessions.Set(UploadSessionTime, session.key(), session); err != nil {
+			return nil, fmt.Errorf("cannot save upload session: %w", err)
+		}
+	}
+	return node, nil
+}
+
+// Upload r content in chunks to session, starting session in first chunk.
+//
+// If session already started r continue from session offset
+func (gdrive *Gdrive) upload(session *UploadSession, r io.Reader) (node *drive.File, err error) {
+	defer gdrive.finishCreate(session, &err)
+	if seeker, ok := r.(io.ReadSeeker); ok && gdrive.Dedup != DedupNone && session.URI == "" && session.FileID == "" {
+		if node, err = gdrive.dedupUpload(session, seeker); err != nil {
+			return nil, err
+		} else if node != nil && session.replace != nil {
+			return gdrive.renameOver(session.replace, node)
+		} else if node != nil {
+			return node, nil
+		}
+	}
+
+	if session.hash == nil && session.Offset == 0 {
+		session.hash = newUploadHash()
+	}
+
+	buff, size, checked := make([]byte, gdrive.uploadChunkSize()), 0, false
+	for node == nil {
+		var n int
+		n, err = io.ReadFull(r, buff[size:])
+		if size += n; session.hash != nil {
+			session.hash.Write(buff[size-n : size])
+		}
+
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return nil, err
+		}
+
+		if session.URI == "" {
+			if session.detect {
+				if session.ContentType = gdrive.detectMime(session.name, buff[:min(size, 512)]); session.ContentType != GoogleDriveMimeFile {
+					session.Metadata.MimeType = session.ContentType
+				}
+			}
+			err = gdrive.waitQuota(session, func() error {
+				if err := gdrive.uploadPaused(); err != nil {
+					return err
+				} else if err = gdrive.startUpload(session); err != nil {
+					return gdrive.pauseUploads(ProcessErr(nil, err))
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Check if remote file changed before commit upload
+		if final && !checked && session.FileID != "" && session.expect != nil {
+			if checked, err = true, gdrive.checkConflict(session.expect); err != nil {
+				gdrive.cancelUpload(session)
+				if seeker, ok := r.(io.ReadSeeker); ok && errors.Is(err, ErrConflict) && gdrive.ConflictPolicy == ConflictCopy {
+					return gdrive.uploadConflict(session, session.expect, seeker)
+				}
+				return nil, err
+			}
+		}
+
+		// Move bytes not confirmed to start of buffer
+		start := session.Offset
+		err = gdrive.waitQuota(session, func() (err error) {
+			if node, err = gdrive.sendRetry(session, buff[session.Offset-start:size], final); err != nil {
+				return gdrive.pauseUploads(ProcessErr(nil, err))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		size = copy(buff, buff[session.Offset-start:size])
+	}
+	gdrive.uploadDone()
+
+	if gdrive.sessions != nil && session.key() != "" {
+		gdrive.sessions.Delete(session.key())
+	}
+
+	// Check content uploaded
+	if session.hash != nil {
+		if err = session.hash.verify(node); err != nil {
+			return nil, errors.Join(err, gdrive.rejectRevision(node))
+		}
+	}
+
+	if gdrive.Dedup != DedupNone && session.hash != nil {
+		node = gdrive.tagHash(node, session.hash)
+	}
+
+	if session.replace != nil {
+		return gdrive.renameOver(session.replace, node)
+	}
+	return node, nil
+}
+
+// Upload reader content in background, pipe reader is closed with upload error
+func (gdrive *Gdrive) uploadBackground(session *UploadSession, reader io.ReadCloser) *pendingUpload {
+	pending := &pendingUpload{done: make(chan struct{})}
+	gdrive.tracker.wait.Add(1)
+	go func() {
+		defer gdrive.tracker.wait.Done()
+		defer close(pending.done)
+		pending.node, pending.err = gdrive.upload(session, reader)
+		if pipe, ok := reader.(*io.PipeReader); ok {
+			pipe.CloseWithError(pending.err) // Return upload error to Writer
+		}
+	}()
+	return pending
+}
+
+// Commit all write handles opened, wait uploads in background and release locks
+func (gdrive *Gdrive) Close() error {
+	gdrive.tracker.locker.Lock()
+	files := slices.Collect(maps.Keys(gdrive.tracker.files))
+	gdrive.tracker.locker.Unlock()
+
+	if gdrive.quota != nil {
+		gdrive.quota.close() // Uploads waiting quota fail now
+	}
+	errs := []error{}
+	for _, file := range files {
+		errs = append(errs, file.Close())
+	}
+	if gdrive.journal != nil {
+		gdrive.journal.stop() // Pending uploads continue on next start
+	}
+	if gdrive.creates != nil {
+		gdrive.creates.stop()
+	}
+	gdrive.tracker.wait.Wait()
+	return errors.Join(append(errs, gdrive.flushCreates(), gdrive.unlockAll())...)
+}
+
+// Continue session from last byte confirmed by Google Drive, r is same content uploaded before
+func (gdrive *Gdrive) resumeSession(session *UploadSession, r io.ReadSeeker) (node *drive.File, err error) {
+	if node, err = gdrive.sendChunk(session, nil, false); err != nil || node != nil {
+		err = ProcessErr(nil, err)
+		gdrive.finishCreate(session, &err)
+		return node, err
+	}
+
+	// Hash content uploaded before continue
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	session.hash = newUploadHash()
+	if _, err = io.CopyN(session.hash, r, session.Offset); err != nil {
+		return nil, err
+	}
+	return gdrive.upload(session, r)
+}
+
+// Continue upload interrupted in previous process, r is same content uploaded before
+func (gdrive *Gdrive) ResumeUpload(name string, r io.ReadSeeker) (fs.FileInfo, error) {
+	name = pathManipulate(name).CleanPath()
+	node, err := gdrive.getNode(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "upload", Path: name, Err: ProcessErr(fileRes(node), err)}
+	}
+
+	var session *UploadSession
+	if gdrive.sessions != nil {
+		if session, err = gdrive.sessions.Get(node.Id); err != nil && err != cache.ErrNotExist {
+			return nil, &fs.PathError{Op: "upload", Path: name, Err: err}
+		}
+	}
+	if session == nil {
+		return nil, &fs.PathError{Op: "upload", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if node, err = gdrive.resumeSession(session, r); err != nil {
+		return nil, &fs.PathError{Op: "upload", Path: name, Err: err}
+	}
+
+	if gdrive.cache != nil {
+		gdrive.cache.Set(DefaultCacheTime, path.Join(gdrive.SubDir, name), node)
+	}
+	return &NodeStat{File: node}, nil
+}
@@ -0,0 +1,118 @@
+package drivefs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	uploadBackoff = time.Millisecond // Retry chunks without wait
+	os.Exit(m.Run())
+}
+
+// Fake resumable session, fail first chunk and return md5 checksum from md5Sum
+func uploadServer(received *[]byte, md5Sum func(data []byte) string) *httptest.Server {
+	failed := false
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		var total string
+		contentRange := r.Header.Get("Content-Range")
+		if strings.HasPrefix(contentRange, "bytes */") {
+			start, end, total = -1, -1, strings.TrimPrefix(contentRange, "bytes */")
+		} else if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		if start >= 0 && !failed {
+			failed = true // Fail first chunk
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		} else if start >= 0 {
+			if start != int64(len(*received)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			*received = append(*received, data...)
+		}
+
+		if total != "*" && strconv.Itoa(len(*received)) == total {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":"test","size":"%d","md5Checksum":%q}`, len(*received), md5Sum(*received))
+			return
+		}
+		if len(*received) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(*received)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+	}))
+}
+
+func TestUploadSession(t *testing.T) {
+	content := make([]byte, UploadChunkAlign*2+1000)
+	rand.Read(content)
+
+	received := []byte{}
+	server := uploadServer(&received, func(data []byte) string { return fmt.Sprintf("%x", md5.Sum(data)) })
+	defer server.Close()
+
+	gdrive := &Gdrive{client: server.Client(), ChunkSize: UploadChunkAlign}
+	node, err := gdrive.upload(&UploadSession{URI: server.URL, FileID: "test"}, bytes.NewReader(content))
+	if err != nil {
+		t.Errorf("cannot upload: %s", err)
+		return
+	} else if node.Size != int64(len(content)) {
+		t.Errorf("invalid size: %d != %d", node.Size, len(content))
+		return
+	} else if !bytes.Equal(received, content) {
+		t.Errorf("content uploaded is not same")
+		return
+	}
+}
+
+func TestUploadChecksum(t *testing.T) {
+	received := []byte{}
+	server := uploadServer(&received, func([]byte) string { return "d41d8cd98f00b204e9800998ecf8427e" })
+	defer server.Close()
+
+	gdrive := &Gdrive{client: server.Client(), ChunkSize: UploadChunkAlign, ChecksumPolicy: ChecksumKeep}
+	if _, err := gdrive.upload(&UploadSession{URI: server.URL, FileID: "test"}, strings.NewReader("Google drive")); !errors.Is(err, ErrChecksum) {
+		t.Errorf("checksum not checked: %v", err)
+	}
+}
+
+func TestUploadStatusFinished(t *testing.T) {
+	chunks := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Range"), "bytes */") {
+			fmt.Fprint(w, `{"id":"test","size":"12"}`) // Upload finished
+			return
+		}
+		if chunks++; chunks == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // Response lost after commit
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest) // Chunk sent again
+	}))
+	defer server.Close()
+
+	gdrive := &Gdrive{client: server.Client(), ChunkSize: UploadChunkAlign}
+	node, err := gdrive.upload(&UploadSession{URI: server.URL, FileID: "test"}, strings.NewReader("Google drive"))
+	if err != nil {
+		t.Errorf("cannot upload: %s", err)
+	} else if node.Id != "test" || chunks != 1 {
+		t.Errorf("chunk sent %d times after upload finished", chunks)
+	}
+}
@@ -0,0 +1,200 @@
+package drivefs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// How Google Workspace files (Docs, Sheets, Slides) are listed
+type WorkspaceMode int
+
+const (
+	WorkspaceHide    WorkspaceMode = iota // Not listed
+	WorkspaceExport                       // Listed with export extension and read with files.export
+	WorkspacePointer                      // Listed as JSON file with document URL and ID
+)
+
+// Export format of Google Workspace file
+type ExportFormat struct {
+	MimeType  string `json:"mime_type"` // Mime type to files.export
+	Extension string `json:"extension"` // Appended to file name, with dot
+}
+
+// Default export formats, key is Google Workspace mime type
+var ExportFormats = map[string]ExportFormat{
+	GoogleDriveMimeDocument:               {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+	GoogleDriveMimeSheet:                  {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+	GoogleDriveMimeSlides:                 {"application/vnd.openxmlformats-officedocument.presentationml.presentation", ".pptx"},
+	"application/vnd.google-apps.drawing": {"application/pdf", ".pdf"},
+	"application/vnd.google-apps.script":  {"application/vnd.google-apps.script+json", ".json"},
+}
+
+// Pointer file extension, key is Google Workspace mime type
+var PointerExtensions = map[string]string{
+	GoogleDriveMimeDocument:                   ".gdoc",
+	GoogleDriveMimeSheet:                      ".gsheet",
+	GoogleDriveMimeSlides:                     ".gslides",
+	"application/vnd.google-apps.drawing":     ".gdraw",
+	"application/vnd.google-apps.form":        ".gform",
+	"application/vnd.google-apps.jam":         ".gjam",
+	"application/vnd.google-apps.map":         ".gmap",
+	"application/vnd.google-apps.script":      ".gscript",
+	"application/vnd.google-apps.site":        ".gsite",
+	"application/vnd.google-apps.fusiontable": ".gtable",
+}
+
+// Content of pointer file
+type workspacePointer struct {
+	URL      string `json:"url"`
+	DocID    string `json:"doc_id"`
+	MimeType string `json:"mime_type"`
+}
+
+// Export format to mime type, Gdrive.ExportFormats override ExportFormats
+func (gdrive *Gdrive) exportFormat(mimeType string) (ExportFormat, bool) {
+	if format, ok := gdrive.ExportFormats[mimeType]; ok {
+		return format, format.MimeType != ""
+	}
+	format, ok := ExportFormats[mimeType]
+	return format, ok
+}
+
+// Extension appended to Google Workspace file name, false if node is not listed
+func (gdrive *Gdrive) workspaceExt(node *drive.File) (string, bool) {
+	switch gdrive.Workspace {
+	case WorkspaceExport:
+		format, ok := gdrive.exportFormat(node.MimeType)
+		return format.Extension, ok
+	case WorkspacePointer:
+		ext, ok := PointerExtensions[node.MimeType]
+		return ext, ok
+	}
+	return "", false
+}
+
+// Return true if node is Google Workspace file listed by Workspace mode
+func (gdrive *Gdrive) isWorkspace(node *drive.File) bool {
+	_, ok := gdrive.workspaceExt(node)
+	return ok && node.Id != ""
+}
+
+// Copy of Google Workspace node with extension in name and size of content
+func (gdrive *Gdrive) workspaceNode(node *drive.File) *drive.File {
+	ext, _ := gdrive.workspaceExt(node)
+	virtual := *node
+	virtual.Name += ext
+	virtual.Size = 0
+	if gdrive.Workspace == WorkspacePointer {
+		virtual.Size = int64(len(workspacePointerContent(node)))
+	} else if size, ok := gdrive.exportSizes.load(exportKey(node)); ok {
+		virtual.Size = size // Size unknown until first export, use workspaceStat to export
+	}
+	return &virtual
+}
+
+// Same as workspaceNode, export file if size is unknown
+func (gdrive *Gdrive) workspaceStat(node *drive.File) (*drive.File, error) {
+	virtual := gdrive.workspaceNode(node)
+	if gdrive.Workspace != WorkspaceExport {
+		return virtual, nil
+	} else if _, ok := gdrive.exportSizes.load(exportKey(node)); ok {
+		return virtual, nil
+	}
+
+	data, err := gdrive.exportContent(node)
+	if err != nil {
+		return nil, err
+	}
+	virtual.Size = int64(len(data))
+	return virtual, nil
+}
+
+// Find Google Workspace file listed as name in folder
+func (gdrive *Gdrive) workspaceFromFolder(folderID, name string) (*drive.File, error) {
+	if gdrive.Workspace == WorkspaceHide {
+		return nil, fs.ErrNotExist
+	}
+
+	for _, mimeType := range DriveMimes {
+		ext, ok := gdrive.workspaceExt(&drive.File{MimeType: mimeType})
+		if !ok || !strings.HasSuffix(name, ext) || name == ext {
+			continue
+		}
+
+		docName := strings.ReplaceAll(strings.ReplaceAll(strings.TrimSuffix(name, ext), `\`, `\\`), `'`, `\'`)
+		res, err := gdrive.driveService.Files.List().Fields("*").PageSize(3).Q(fmt.Sprintf(GoogleListQueryWithName+" and mimeType = '%s'", folderID, docName, mimeType)).Do()
+		if err != nil {
+			return nil, ProcessErr(nil, err)
+		} else if len(res.Files) == 1 {
+			return gdrive.workspaceStat(res.Files[0])
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+func workspacePointerContent(node *drive.File) []byte {
+	data, _ := json.Marshal(workspacePointer{URL: node.WebViewLink, DocID: node.Id, MimeType: node.MimeType})
+	return append(data, '\n')
+}
+
+// Key of exported size, changed on each edit
+func exportKey(node *drive.File) string { return node.Id + ":" + node.ModifiedTime }
+
+// Export Google Workspace file or make pointer file, content is in memory because files.export is limited to 10MB
+func (gdrive *Gdrive) openWorkspace(name string, node *drive.File) (*DocFile, error) {
+	if gdrive.Workspace == WorkspacePointer {
+		return &DocFile{Reader: bytes.NewReader(workspacePointerContent(node)), Node: node, name: name}, nil
+	}
+
+	data, err := gdrive.exportContent(node)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	exported := *node
+	exported.Size = int64(len(data))
+	return &DocFile{Reader: bytes.NewReader(data), Node: &exported, name: name}, nil
+}
+
+// Export Google Workspace file content and save size
+func (gdrive *Gdrive) exportContent(node *drive.File) ([]byte, error) {
+	format, _ := gdrive.exportFormat(node.MimeType)
+	res, err := gdrive.driveService.Files.Export(node.Id, format.MimeType).Download()
+	if err != nil {
+		return nil, ProcessErr(httpRes(res), err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(&throttledReader{ReadCloser: res.Body, client: gdrive})
+	if err != nil {
+		return nil, ProcessErr(nil, err)
+	}
+	gdrive.exportSizes.store(exportKey(node), int64(len(data)))
+	return data, nil
+}
+
+// Sizes of exported Google Workspace files, shared with Sub
+type exportSizes struct{ sizes sync.Map }
+
+func (sizes *exportSizes) load(key string) (int64, bool) {
+	if sizes == nil {
+		return 0, false
+	}
+	size, ok := sizes.sizes.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return size.(int64), true
+}
+
+func (sizes *exportSizes) store(key string, size int64) {
+	if sizes != nil {
+		sizes.sizes.Store(key, size)
+	}
+}
@@ -0,0 +1,78 @@
+package drivefs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestWorkspaceNode(t *testing.T) {
+	doc := &drive.File{Id: "doc", Name: "report", MimeType: GoogleDriveMimeDocument, ModifiedTime: "2026-01-02T03:04:05Z"}
+	gdrive := &Gdrive{Workspace: WorkspaceExport, exportSizes: &exportSizes{}}
+	if node := gdrive.workspaceNode(doc); node.Name != "report.docx" || node.Size != 0 {
+		t.Errorf("export node %q with size %d", node.Name, node.Size)
+	}
+
+	gdrive.exportSizes.store(exportKey(doc), 1234)
+	gdrive.ExportFormats = map[string]ExportFormat{GoogleDriveMimeDocument: {"application/pdf", ".pdf"}}
+	if node := gdrive.workspaceNode(doc); node.Name != "report.pdf" || node.Size != 1234 {
+		t.Errorf("export node %q with size %d", node.Name, node.Size)
+	}
+
+	gdrive.Workspace = WorkspacePointer
+	if node := gdrive.workspaceNode(doc); node.Name != "report.gdoc" || node.Size != int64(len(workspacePointerContent(doc))) {
+		t.Errorf("pointer node %q with size %d", node.Name, node.Size)
+	} else if mode := (&NodeStat{File: node}).Mode(); mode != 0444 {
+		t.Errorf("pointer mode %s", mode)
+	}
+
+	gdrive.Workspace = WorkspaceHide
+	if gdrive.isWorkspace(doc) {
+		t.Error("document listed with WorkspaceHide")
+	}
+}
+
+func TestWorkspaceLookup(t *testing.T) {
+	doc := &drive.File{Id: "doc", Name: "report", MimeType: GoogleDriveMimeDocument, ModifiedTime: "2026-01-02T03:04:05Z"}
+	exports := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/export"):
+			exports++
+			w.Write([]byte("exported content"))
+		case strings.Contains(r.URL.Query().Get("q"), "name = 'report'"):
+			json.NewEncoder(w).Encode(&drive.FileList{Files: []*drive.File{doc}})
+		default:
+			json.NewEncoder(w).Encode(&drive.FileList{})
+		}
+	}))
+	defer server.Close()
+
+	service, _ := testService(t, server)
+	gdrive := &Gdrive{
+		Workspace:    WorkspaceExport,
+		driveService: service,
+		rootDrive:    &drive.File{Id: "root", MimeType: GoogleDriveMimeFolder},
+		exportSizes:  &exportSizes{},
+	}
+
+	if _, err := gdrive.getNode("report"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("document found without extension: %v", err)
+	}
+	for range 2 {
+		if node, err := gdrive.getNode("report.docx"); err != nil {
+			t.Fatal(err)
+		} else if node.Size != int64(len("exported content")) {
+			t.Errorf("size %d before open, expected size of export", node.Size)
+		}
+	}
+	if exports != 1 {
+		t.Errorf("exported %d times, expected only first stat", exports)
+	}
+}